@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewCreateRepoCmd() *cobra.Command {
+
+	createrepoCmd := &cobra.Command{
+		Use:   "createrepo <directory>",
+		Short: "create rpm-md repository metadata for a directory of RPMs",
+		Long:  `Reads the headers of all RPMs in the directory and writes repodata/repomd.xml, primary.xml.gz and filelists.xml.gz, replacing existing metadata`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := repo.CreateRepo(args[0]); err != nil {
+				return err
+			}
+			logrus.Info("Done.")
+			return nil
+		},
+	}
+	return createrepoCmd
+}
@@ -6,7 +6,10 @@ import (
 )
 
 type FetchOpts struct {
-	repofile string
+	repofile  string
+	workers   int
+	filelists bool
+	probe     bool
 }
 
 var fetchopts = &FetchOpts{}
@@ -22,10 +25,17 @@ func NewFetchCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			return repo.NewRemoteRepoFetcher(repos.Repositories, ".bazeldnf").Fetch()
+			fetcher, err := repo.NewRemoteRepoFetcher(repos.Repositories, ".bazeldnf", fetchopts.workers, fetchopts.filelists, fetchopts.probe)
+			if err != nil {
+				return err
+			}
+			return fetcher.Fetch()
 		},
 	}
 
 	fetchCmd.Flags().StringVarP(&fetchopts.repofile, "repofile", "r", "repo.yaml", "repository information file")
+	fetchCmd.Flags().IntVarP(&fetchopts.workers, "workers", "j", repo.DefaultFetchWorkers, "number of repositories to fetch in parallel")
+	fetchCmd.Flags().BoolVar(&fetchopts.filelists, "filelists", false, "fetch the filelists metadata too, required for resolving with --filelists")
+	fetchCmd.Flags().BoolVar(&fetchopts.probe, "probe-mirrors", false, "measure the latency of all mirrors and prefer the fastest ones in generated rpm rules")
 	return fetchCmd
 }
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/spf13/cobra"
 )
 
 type GetOpts struct {
-	arch string
-	fc   string
-	out  string
+	arch       string
+	fc         string
+	out        string
+	repoFiles  []string
+	releasever string
+	distro     string
+	release    string
+	append     bool
 }
 
 var getopts = GetOpts{}
@@ -17,19 +25,41 @@ func NewInitCmd() *cobra.Command {
 
 	initCmd := &cobra.Command{
 		Use:   "init",
-		Short: "Create basic repo.yaml files for fedora releases",
-		Long:  `Create proper repo information with release- and update repos for fedora releases`,
+		Short: "Create basic repo.yaml files for fedora and other rpm based distributions",
+		Long:  `Create proper repo information with the usual repos of fedora, centos-stream, rocky, almalinux and opensuse releases`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return repo.NewRemoteInit(getopts.fc, getopts.arch, getopts.out).Init()
+			if len(getopts.repoFiles) > 0 {
+				releasever := getopts.releasever
+				if releasever == "" {
+					releasever = getopts.release
+				}
+				if releasever == "" {
+					releasever = strings.TrimPrefix(getopts.fc, "f")
+				}
+				init := repo.NewRepoFileInit(getopts.repoFiles, releasever, getopts.arch, getopts.out)
+				init.Append = getopts.append
+				return init.Init()
+			}
+			var init *repo.RepoInit
+			if getopts.distro != "" {
+				init = repo.NewDistroInit(getopts.distro, getopts.release, getopts.arch, getopts.out)
+			} else if getopts.fc != "" {
+				init = repo.NewRemoteInit(getopts.fc, getopts.arch, getopts.out)
+			} else {
+				return fmt.Errorf("either --fc, --distro or --from-repo-file has to be specified")
+			}
+			init.Append = getopts.append
+			return init.Init()
 		},
 	}
 
-	initCmd.Flags().StringVarP(&getopts.arch, "arch", "a", "x86_64", "target fedora architecture")
-	initCmd.Flags().StringVarP(&getopts.fc, "fc", "", "", "target fedora core release")
+	initCmd.Flags().StringVarP(&getopts.arch, "arch", "a", "x86_64", "target architecture")
+	initCmd.Flags().StringVarP(&getopts.fc, "fc", "", "", "target fedora core release, same as --distro fedora --release <fc>")
+	initCmd.Flags().StringVar(&getopts.distro, "distro", "", fmt.Sprintf("target distribution, one of %s", strings.Join(repo.Distros(), ", ")))
+	initCmd.Flags().StringVar(&getopts.release, "release", "", "target release of the distribution")
+	initCmd.Flags().BoolVar(&getopts.append, "append", false, "add the repositories to an existing repository file instead of failing")
 	initCmd.Flags().StringVarP(&getopts.out, "output", "o", "repo.yaml", "where to write the repository information")
-	err := initCmd.MarkFlagRequired("fc")
-	if err != nil {
-		panic(err)
-	}
+	initCmd.Flags().StringArrayVar(&getopts.repoFiles, "from-repo-file", nil, "import the repositories of a dnf/yum .repo file instead of writing the fedora repositories")
+	initCmd.Flags().StringVar(&getopts.releasever, "releasever", "", "value for $releasever in imported .repo files, defaults to the --release or --fc release")
 	return initCmd
 }
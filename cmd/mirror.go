@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/bazel"
+	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type mirrorOpts struct {
+	repofile    string
+	workspace   string
+	dir         string
+	workers     int
+	urlTemplate string
+}
+
+var mirroropts = mirrorOpts{}
+
+func NewMirrorCmd() *cobra.Command {
+
+	mirrorCmd := &cobra.Command{
+		Use:   "mirror",
+		Short: "download all RPMs of the workspace for offline builds",
+		Long:  `Downloads the RPMs of all rpm rules in the workspace into a directory where each RPM is named after its sha256 sum`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workspace, err := bazel.LoadWorkspace(mirroropts.workspace)
+			if err != nil {
+				return fmt.Errorf("failed to open workspace %s: %v", mirroropts.workspace, err)
+			}
+			// the repository file is optional and only provides credentials and certificates
+			repos := &bazeldnf.Repositories{}
+			if _, err := os.Stat(mirroropts.repofile); err == nil || cmd.Flags().Changed("repofile") {
+				if repos, err = repo.LoadRepoFile(mirroropts.repofile); err != nil {
+					return err
+				}
+			}
+			getter, err := repo.NewGetter(repos.Repositories)
+			if err != nil {
+				return err
+			}
+			rpms := bazel.GetRPMs(workspace)
+			downloads := []repo.RPMDownload{}
+			for _, rpm := range rpms {
+				downloads = append(downloads, repo.RPMDownload{
+					Name:   rpm.Name(),
+					SHA256: rpm.SHA256(),
+					URLs:   rpm.URLs(),
+				})
+			}
+			if err := repo.NewRPMDownloader(getter, mirroropts.dir, mirroropts.workers).Download(downloads); err != nil {
+				return err
+			}
+			logrus.Infof("Mirrored %d RPMs to %s", len(rpms), mirroropts.dir)
+
+			if mirroropts.urlTemplate != "" {
+				for _, rpm := range rpms {
+					rpm.AddURLTemplate(mirroropts.urlTemplate)
+				}
+				if err := bazel.WriteWorkspace(false, workspace, mirroropts.workspace); err != nil {
+					return err
+				}
+			}
+			logrus.Info("Done.")
+			return nil
+		},
+	}
+
+	mirrorCmd.Flags().StringVarP(&mirroropts.repofile, "repofile", "r", "repo.yaml", "repository file with credentials and certificates, used if it exists")
+	mirrorCmd.Flags().StringVarP(&mirroropts.workspace, "workspace", "w", "WORKSPACE", "Bazel workspace file")
+	mirrorCmd.Flags().StringVarP(&mirroropts.dir, "dir", "d", "rpms", "directory where the RPMs are stored under their sha256 sum")
+	mirrorCmd.Flags().IntVarP(&mirroropts.workers, "workers", "j", repo.DefaultDownloadWorkers, "number of RPMs to download in parallel")
+	mirrorCmd.Flags().StringVar(&mirroropts.urlTemplate, "url-template", "", "add the URL of the internal mirror to the rpm rules, {sha256}, {name} and {filename} are replaced (e.g. https://cache.internal/{sha256})")
+	return mirrorCmd
+}
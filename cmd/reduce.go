@@ -16,10 +16,16 @@ import (
 type reduceOpts struct {
 	in               []string
 	repofile         string
+	filelists        bool
+	weakDeps         bool
+	exclude          []string
+	locks            []string
+	modules          []string
 	out              string
 	lang             string
 	nobest           bool
 	arch             string
+	multilib         bool
 	fedoraBaseSystem string
 }
 
@@ -43,7 +49,19 @@ which allow reducing huge rpm repos to a smaller problem set for debugging, remo
 					return err
 				}
 			}
-			repo := reducer.NewRepoReducer(repos, reduceopts.in, reduceopts.lang, reduceopts.fedoraBaseSystem, reduceopts.arch, ".bazeldnf")
+			packagePolicy, err := loadPolicy(repos, reduceopts.exclude, reduceopts.locks)
+			if err != nil {
+				return err
+			}
+			repo := reducer.NewRepoReducer(repos, reduceopts.in, reduceopts.arch, ".bazeldnf", reducer.Options{
+				Lang:          reduceopts.lang,
+				FedoraRelease: reduceopts.fedoraBaseSystem,
+				Multilib:      reduceopts.multilib,
+				Filelists:     reduceopts.filelists,
+				WeakDeps:      reduceopts.weakDeps,
+				Modules:       enabledModules(repos, reduceopts.modules),
+				Policy:        packagePolicy,
+			})
 			logrus.Info("Loading packages.")
 			if err := repo.Load(); err != nil {
 				return err
@@ -74,7 +92,14 @@ which allow reducing huge rpm repos to a smaller problem set for debugging, remo
 	reduceCmd.PersistentFlags().StringVarP(&reduceopts.out, "output", "o", "debug.xml", "where to write the repository file")
 	reduceCmd.PersistentFlags().StringVarP(&reduceopts.fedoraBaseSystem, "fedora-base-system", "f", "fedora-release-container", "fedora base system to choose from (e.g. fedora-release-server, fedora-release-container, ...)")
 	reduceCmd.PersistentFlags().StringVarP(&reduceopts.arch, "arch", "a", "x86_64", "target fedora architecture")
+	reduceCmd.PersistentFlags().BoolVar(&reduceopts.multilib, "multilib", false, "allow picking packages of multilib architectures like i686 on x86_64")
 	reduceCmd.PersistentFlags().BoolVarP(&reduceopts.nobest, "nobest", "n", false, "allow picking versions which are not the newest")
 	reduceCmd.PersistentFlags().StringVarP(&reduceopts.repofile, "repofile", "r", "repo.yaml", "repository information file. Will be used by default if no explicit inputs are provided.")
+	reduceCmd.PersistentFlags().BoolVar(&reduceopts.filelists, "filelists", false, "resolve requirements on files which are only listed in the filelists metadata (requires fetch --filelists)")
+	reduceCmd.PersistentFlags().StringVar(&reduceopts.lang, "lang", "", "comma separated list of locales like en,de_DE whose language packs may be pulled in, all others are dropped")
+	reduceCmd.PersistentFlags().BoolVar(&reduceopts.weakDeps, "with-weak-deps", false, "try to install recommended and supplementing packages too, without failing if they can't be installed")
+	reduceCmd.PersistentFlags().StringArrayVar(&reduceopts.exclude, "exclude", nil, "never pick packages whose name matches the given pattern (e.g. systemd*)")
+	reduceCmd.PersistentFlags().StringArrayVar(&reduceopts.locks, "lock", nil, "only pick the given version of a package (e.g. glibc=2.32-4)")
+	reduceCmd.PersistentFlags().StringArrayVar(&reduceopts.modules, "module", nil, "enable the given module stream instead of the default one (e.g. nodejs:12)")
 	return reduceCmd
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/policy"
 	"github.com/rmohr/bazeldnf/pkg/reducer"
 	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/rmohr/bazeldnf/pkg/sat"
@@ -16,8 +18,15 @@ type resolveOpts struct {
 	lang             string
 	nobest           bool
 	arch             string
+	multilib         bool
 	fedoraBaseSystem string
 	repofile         string
+	filelists        bool
+	weakDeps         bool
+	exclude          []string
+	locks            []string
+	modules          []string
+	minimize         string
 }
 
 var resolveopts = resolveOpts{}
@@ -30,6 +39,10 @@ func NewResolveCmd() *cobra.Command {
 		Long:  `resolves dependencies of the given packages with the assumption of a SCRATCH container as install target`,
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, required []string) error {
+			objective, err := sat.ParseObjective(resolveopts.minimize)
+			if err != nil {
+				return err
+			}
 			repos := &bazeldnf.Repositories{}
 			if len(resolveopts.in) == 0 {
 				var err error
@@ -38,7 +51,19 @@ func NewResolveCmd() *cobra.Command {
 					return err
 				}
 			}
-			repo := reducer.NewRepoReducer(repos, resolveopts.in, resolveopts.lang, resolveopts.fedoraBaseSystem, resolveopts.arch, ".bazeldnf")
+			packagePolicy, err := loadPolicy(repos, resolveopts.exclude, resolveopts.locks)
+			if err != nil {
+				return err
+			}
+			repo := reducer.NewRepoReducer(repos, resolveopts.in, resolveopts.arch, ".bazeldnf", reducer.Options{
+				Lang:          resolveopts.lang,
+				FedoraRelease: resolveopts.fedoraBaseSystem,
+				Multilib:      resolveopts.multilib,
+				Filelists:     resolveopts.filelists,
+				WeakDeps:      resolveopts.weakDeps,
+				Modules:       enabledModules(repos, resolveopts.modules),
+				Policy:        packagePolicy,
+			})
 			logrus.Info("Loading packages.")
 			if err := repo.Load(); err != nil {
 				return err
@@ -48,7 +73,7 @@ func NewResolveCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			solver := sat.NewResolver(resolveopts.nobest)
+			solver := sat.NewResolver(resolveopts.nobest, objective, resolveopts.weakDeps, packagePolicy)
 			logrus.Info("Loading involved packages into the resolver.")
 			err = solver.LoadInvolvedPackages(involved)
 			if err != nil {
@@ -62,9 +87,11 @@ func NewResolveCmd() *cobra.Command {
 			logrus.Info("Solving.")
 			install, _, err := solver.Resolve()
 			if err != nil {
-				return err
+				return explainFailure(solver, err)
+			}
+			for _, pkg := range install {
+				fmt.Printf("%s.%s from %s\n", pkg.String(), pkg.Arch, pkg.RepositoryName())
 			}
-			fmt.Println(install)
 			fmt.Println(len(install))
 			logrus.Info("Done.")
 			return nil
@@ -74,7 +101,59 @@ func NewResolveCmd() *cobra.Command {
 	resolveCmd.PersistentFlags().StringArrayVarP(&resolveopts.in, "input", "i", nil, "primary.xml of the repository")
 	resolveCmd.PersistentFlags().StringVarP(&resolveopts.fedoraBaseSystem, "fedora-base-system", "f", "fedora-release-container", "fedora base system to choose from (e.g. fedora-release-server, fedora-release-container, ...)")
 	resolveCmd.PersistentFlags().StringVarP(&resolveopts.arch, "arch", "a", "x86_64", "target fedora architecture")
+	resolveCmd.PersistentFlags().BoolVar(&resolveopts.multilib, "multilib", false, "allow picking packages of multilib architectures like i686 on x86_64")
 	resolveCmd.PersistentFlags().BoolVarP(&resolveopts.nobest, "nobest", "n", false, "allow picking versions which are not the newest")
 	resolveCmd.PersistentFlags().StringVarP(&resolveopts.repofile, "repofile", "r", "repo.yaml", "repository information file. Will be used by default if no explicit inputs are provided.")
+	resolveCmd.PersistentFlags().BoolVar(&resolveopts.filelists, "filelists", false, "resolve requirements on files which are only listed in the filelists metadata (requires fetch --filelists)")
+	resolveCmd.PersistentFlags().StringVar(&resolveopts.lang, "lang", "", "comma separated list of locales like en,de_DE whose language packs may be pulled in, all others are dropped")
+	resolveCmd.PersistentFlags().BoolVar(&resolveopts.weakDeps, "with-weak-deps", false, "try to install recommended and supplementing packages too, without failing if they can't be installed")
+	resolveCmd.PersistentFlags().StringArrayVar(&resolveopts.exclude, "exclude", nil, "never pick packages whose name matches the given pattern (e.g. systemd*)")
+	resolveCmd.PersistentFlags().StringArrayVar(&resolveopts.locks, "lock", nil, "only pick the given version of a package (e.g. glibc=2.32-4)")
+	resolveCmd.PersistentFlags().StringArrayVar(&resolveopts.modules, "module", nil, "enable the given module stream instead of the default one (e.g. nodejs:12)")
+	resolveCmd.PersistentFlags().StringVar(&resolveopts.minimize, "minimize", "", "pick the solution with the least amount of packages (\"packages\") or with the smallest installed size (\"size\") instead of the first one found")
 	return resolveCmd
 }
+
+// explainFailure turns the reasons why the solver could not find a solution into an error
+func explainFailure(solver *sat.Resolver, err error) error {
+	logrus.Info("Looking for the reasons why no solution can be found.")
+	reasons, explainErr := solver.Explain()
+	if explainErr != nil {
+		logrus.Warnf("Failed to explain why no solution can be found: %v", explainErr)
+		return err
+	}
+	return fmt.Errorf("no solution found:\n  %s", strings.Join(reasons, "\n  "))
+}
+
+// loadPolicy combines the excludes and locks of the repository file with the ones given on the command line. Locks
+// given on the command line replace locks of the same package in the repository file.
+func loadPolicy(repos *bazeldnf.Repositories, excludes []string, locks []string) (*policy.Policy, error) {
+	overridden := map[string]bool{}
+	for _, lock := range locks {
+		overridden[strings.SplitN(lock, "=", 2)[0]] = true
+	}
+	allLocks := []string{}
+	for _, lock := range repos.Locks {
+		if !overridden[strings.SplitN(lock, "=", 2)[0]] {
+			allLocks = append(allLocks, lock)
+		}
+	}
+	allLocks = append(allLocks, locks...)
+	return policy.New(append(append([]string{}, repos.Exclude...), excludes...), allLocks)
+}
+
+// enabledModules combines the module streams of the repo.yaml file with the ones given on the command line, which
+// replace streams of the same module
+func enabledModules(repos *bazeldnf.Repositories, modules []string) []string {
+	overridden := map[string]bool{}
+	for _, module := range modules {
+		overridden[strings.SplitN(module, ":", 2)[0]] = true
+	}
+	all := []string{}
+	for _, module := range repos.Modules {
+		if !overridden[strings.SplitN(module, ":", 2)[0]] {
+			all = append(all, module)
+		}
+	}
+	return append(all, modules...)
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	"github.com/rmohr/bazeldnf/pkg/bazel"
+	"github.com/rmohr/bazeldnf/pkg/policy"
 	"github.com/rmohr/bazeldnf/pkg/reducer"
 	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/rmohr/bazeldnf/pkg/sat"
@@ -12,9 +17,16 @@ import (
 type rpmtreeOpts struct {
 	lang             string
 	nobest           bool
-	arch             string
+	arches           []string
+	multilib         bool
 	fedoraBaseSystem string
 	repofile         string
+	filelists        bool
+	weakDeps         bool
+	exclude          []string
+	locks            []string
+	modules          []string
+	minimize         string
 	workspace        string
 	buildfile        string
 	name             string
@@ -30,33 +42,15 @@ func NewrpmtreeCmd() *cobra.Command {
 		Short: "Writes a rpmtree rule and its rpmdependencies to bazel files",
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, required []string) error {
-			repos, err := repo.LoadRepoFile(reduceopts.repofile)
+			objective, err := sat.ParseObjective(rpmtreeopts.minimize)
 			if err != nil {
 				return err
 			}
-			repoReducer := reducer.NewRepoReducer(repos, nil, rpmtreeopts.lang, rpmtreeopts.fedoraBaseSystem, rpmtreeopts.arch, ".bazeldnf")
-			logrus.Info("Loading packages.")
-			if err := repoReducer.Load(); err != nil {
-				return err
-			}
-			logrus.Info("Initial reduction of involved packages.")
-			matched, involved, err := repoReducer.Resolve(required)
-			if err != nil {
-				return err
-			}
-			solver := sat.NewResolver(rpmtreeopts.nobest)
-			logrus.Info("Loading involved packages into the rpmtreer.")
-			err = solver.LoadInvolvedPackages(involved)
-			if err != nil {
-				return err
-			}
-			logrus.Info("Adding required packages to the rpmtreer.")
-			err = solver.ConstructRequirements(matched)
+			repos, err := repo.LoadRepoFile(reduceopts.repofile)
 			if err != nil {
 				return err
 			}
-			logrus.Info("Solving.")
-			install, _, err := solver.Resolve()
+			packagePolicy, err := loadPolicy(repos, rpmtreeopts.exclude, rpmtreeopts.locks)
 			if err != nil {
 				return err
 			}
@@ -68,8 +62,20 @@ func NewrpmtreeCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			bazel.AddRPMs(workspace, install, rpmtreeopts.arch)
-			bazel.AddTree(rpmtreeopts.name, build, install, rpmtreeopts.arch, rpmtreeopts.public)
+			for _, arch := range rpmtreeopts.arches {
+				install, err := resolveTree(repos, arch, objective, packagePolicy, required)
+				if err != nil {
+					return err
+				}
+				// with several architectures every architecture gets its own rpmtree
+				name := rpmtreeopts.name
+				if len(rpmtreeopts.arches) > 1 {
+					name = name + "_" + arch
+				}
+				warnLocalURLs(install)
+				bazel.AddRPMs(workspace, install, arch)
+				bazel.AddTree(name, build, install, arch, rpmtreeopts.public)
+			}
 			bazel.PruneRPMs(build, workspace)
 			logrus.Info("Writing bazel files.")
 			err = bazel.WriteWorkspace(false, workspace, rpmtreeopts.workspace)
@@ -87,13 +93,74 @@ func NewrpmtreeCmd() *cobra.Command {
 	}
 
 	rpmtreeCmd.PersistentFlags().StringVarP(&rpmtreeopts.fedoraBaseSystem, "fedora-base-system", "f", "fedora-release-container", "fedora base system to choose from (e.g. fedora-release-server, fedora-release-container, ...)")
-	rpmtreeCmd.PersistentFlags().StringVarP(&rpmtreeopts.arch, "arch", "a", "x86_64", "target fedora architecture")
+	rpmtreeCmd.PersistentFlags().StringSliceVarP(&rpmtreeopts.arches, "arch", "a", []string{"x86_64"}, "target fedora architectures, with several architectures an rpmtree named <name>_<arch> is written for every architecture")
+	rpmtreeCmd.PersistentFlags().BoolVar(&rpmtreeopts.multilib, "multilib", false, "allow picking packages of multilib architectures like i686 on x86_64")
 	rpmtreeCmd.PersistentFlags().BoolVarP(&rpmtreeopts.nobest, "nobest", "n", false, "allow picking versions which are not the newest")
 	rpmtreeCmd.PersistentFlags().BoolVarP(&rpmtreeopts.public, "public", "p", true, "if the rpmtree rule should be public")
 	rpmtreeCmd.PersistentFlags().StringVarP(&rpmtreeopts.repofile, "repofile", "r", "repo.yaml", "repository information file. Will be used by default if no explicit inputs are provided.")
+	rpmtreeCmd.PersistentFlags().BoolVar(&rpmtreeopts.filelists, "filelists", false, "resolve requirements on files which are only listed in the filelists metadata (requires fetch --filelists)")
+	rpmtreeCmd.PersistentFlags().StringVar(&rpmtreeopts.lang, "lang", "", "comma separated list of locales like en,de_DE whose language packs may be pulled in, all others are dropped")
+	rpmtreeCmd.PersistentFlags().BoolVar(&rpmtreeopts.weakDeps, "with-weak-deps", false, "try to install recommended and supplementing packages too, without failing if they can't be installed")
+	rpmtreeCmd.PersistentFlags().StringArrayVar(&rpmtreeopts.exclude, "exclude", nil, "never pick packages whose name matches the given pattern (e.g. systemd*)")
+	rpmtreeCmd.PersistentFlags().StringArrayVar(&rpmtreeopts.locks, "lock", nil, "only pick the given version of a package (e.g. glibc=2.32-4)")
+	rpmtreeCmd.PersistentFlags().StringArrayVar(&rpmtreeopts.modules, "module", nil, "enable the given module stream instead of the default one (e.g. nodejs:12)")
+	rpmtreeCmd.PersistentFlags().StringVar(&rpmtreeopts.minimize, "minimize", "", "pick the solution with the least amount of packages (\"packages\") or with the smallest installed size (\"size\") instead of the first one found")
 	rpmtreeCmd.PersistentFlags().StringVarP(&rpmtreeopts.workspace, "workspace", "w", "WORKSPACE", "Bazel workspace file")
 	rpmtreeCmd.PersistentFlags().StringVarP(&rpmtreeopts.buildfile, "buildfile", "b", "rpm/BUILD.bazel", "Build file for RPMs")
 	rpmtreeCmd.Flags().StringVarP(&rpmtreeopts.name, "name", "", "", "rpmtree rule name")
 	rpmtreeCmd.MarkFlagRequired("name")
 	return rpmtreeCmd
 }
+
+// resolveTree resolves the required packages for a single architecture
+func resolveTree(repos *bazeldnf.Repositories, arch string, objective sat.Objective, packagePolicy *policy.Policy, required []string) ([]*api.Package, error) {
+	repoReducer := reducer.NewRepoReducer(repos, nil, arch, ".bazeldnf", reducer.Options{
+		Lang:          rpmtreeopts.lang,
+		FedoraRelease: rpmtreeopts.fedoraBaseSystem,
+		Multilib:      rpmtreeopts.multilib,
+		Filelists:     rpmtreeopts.filelists,
+		WeakDeps:      rpmtreeopts.weakDeps,
+		Modules:       enabledModules(repos, rpmtreeopts.modules),
+		Policy:        packagePolicy,
+	})
+	logrus.Infof("Loading packages for %s.", arch)
+	if err := repoReducer.Load(); err != nil {
+		return nil, err
+	}
+	logrus.Info("Initial reduction of involved packages.")
+	matched, involved, err := repoReducer.Resolve(required)
+	if err != nil {
+		return nil, err
+	}
+	solver := sat.NewResolver(rpmtreeopts.nobest, objective, rpmtreeopts.weakDeps, packagePolicy)
+	logrus.Info("Loading involved packages into the rpmtreer.")
+	err = solver.LoadInvolvedPackages(involved)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Info("Adding required packages to the rpmtreer.")
+	err = solver.ConstructRequirements(matched)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Info("Solving.")
+	install, _, err := solver.Resolve()
+	if err != nil {
+		return nil, explainFailure(solver, err)
+	}
+	return install, nil
+}
+
+// warnLocalURLs points out repositories whose rpm rules can only be downloaded on machines with the same local files
+func warnLocalURLs(pkgs []*api.Package) {
+	warned := map[string]bool{}
+	for _, pkg := range pkgs {
+		if pkg.Repository == nil || warned[pkg.Repository.Name] || len(pkg.Repository.Mirrors) == 0 {
+			continue
+		}
+		if mirror := pkg.Repository.Mirrors[0]; strings.HasPrefix(mirror, "file://") {
+			logrus.Warnf("RPMs of %s are downloaded from %s, the workspace only works where this path exists. Set preferredMirrors to a shared location of the RPMs.", pkg.Repository.Name, mirror)
+			warned[pkg.Repository.Name] = true
+		}
+	}
+}
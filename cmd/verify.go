@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"net/http"
 
 	"github.com/rmohr/bazeldnf/pkg/bazel"
 	"github.com/rmohr/bazeldnf/pkg/repo"
@@ -34,10 +33,14 @@ func NewVerifyCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			getter, err := repo.NewGetter(repos.Repositories)
+			if err != nil {
+				return err
+			}
 			keyring := openpgp.EntityList{}
 			for _, repo := range repos.Repositories {
 				if !repo.Disabled && repo.GPGKey != "" {
-					resp, err := http.Get(repo.GPGKey)
+					resp, err := getter.Get(repo.GPGKey)
 					if err != nil {
 						return fmt.Errorf("could not fetch gpgkey %s: %v", repo.GPGKey, err)
 					}
@@ -57,7 +60,7 @@ func NewVerifyCmd() *cobra.Command {
 				return fmt.Errorf("failed to open workspace %s: %v", verifyopts.workspace, err)
 			}
 			for _, rpm := range bazel.GetRPMs(workspace) {
-				err := verify(rpm, keyring)
+				err := verify(getter, rpm, keyring)
 				if err != nil {
 					return fmt.Errorf("Could not verify %s: %v", rpm.Name(), err)
 				}
@@ -71,7 +74,7 @@ func NewVerifyCmd() *cobra.Command {
 	return verifyCmd
 }
 
-func verify(rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
+func verify(getter repo.Getter, rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
 	// Force a test. If `nil` the verification library just does no GPG check
 	if keyring == nil {
 		keyring = openpgp.EntityList{}
@@ -80,7 +83,7 @@ func verify(rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
 	log.Infof("Verifying %s", rpm.Name())
 	for _, url := range rpm.URLs() {
 		sha := sha256.New()
-		resp, err := http.Get(url)
+		resp, err := getter.Get(url)
 		if err != nil {
 			log.Warningf("Failed to download %s: %v", rpm.Name(), err)
 			continue
@@ -10,6 +10,7 @@ import (
 const (
 	PrimaryFileType   = "primary"
 	FilelistsFileType = "filelists"
+	ModulesFileType   = "modules"
 )
 
 type URL struct {
@@ -126,7 +127,7 @@ type Repomd struct {
 	XMLName  xml.Name `xml:"repomd"`
 	Text     string   `xml:",chardata"`
 	Xmlns    string   `xml:"xmlns,attr"`
-	Rpm      string   `xml:"rpm,attr"`
+	Rpm      string   `xml:"rpm,attr,omitempty"`
 	Revision string   `xml:"revision"`
 	Data     []Data   `xml:"data"`
 }
@@ -156,10 +157,10 @@ func (r *Repomd) Filelists() *Data {
 type Entry struct {
 	Text  string `xml:",chardata"`
 	Name  string `xml:"name,attr"`
-	Flags string `xml:"flags,attr"`
-	Epoch string `xml:"epoch,attr"`
-	Ver   string `xml:"ver,attr"`
-	Rel   string `xml:"rel,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
 }
 
 func (e Entry) String() string {
@@ -181,7 +182,7 @@ type Dependencies struct {
 
 type ProvidedFile struct {
 	Text string `xml:",chardata"`
-	Type string `xml:"type,attr"`
+	Type string `xml:"type,attr,omitempty"`
 }
 
 type Version struct {
@@ -268,6 +269,14 @@ func (p *Package) String() string {
 	return p.Name + "-" + p.Version.String()
 }
 
+// RepositoryName returns the name of the repository the package was loaded from
+func (p *Package) RepositoryName() string {
+	if p.Repository == nil {
+		return "@local"
+	}
+	return p.Repository.Name
+}
+
 type Repository struct {
 	XMLName      xml.Name  `xml:"metadata"`
 	Text         string    `xml:",chardata"`
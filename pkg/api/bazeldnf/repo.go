@@ -1,15 +1,52 @@
 package bazeldnf
 
+const (
+	// DefaultPriority is the priority of repositories without an explicit priority. Like with dnf, repositories
+	// with a lower value take precedence.
+	DefaultPriority = 99
+	// DefaultCost is the cost of repositories without an explicit cost
+	DefaultCost = 1000
+)
+
 type Repositories struct {
 	Repositories []Repository `json:"repositories"`
+	Exclude      []string     `json:"exclude,omitempty"`
+	Locks        []string     `json:"locks,omitempty"`
+	Modules      []string     `json:"modules,omitempty"`
 }
 
 type Repository struct {
-	Name     string   `json:"name"`
-	Disabled bool     `json:"disabled,omitempty"`
-	Metalink string   `json:"metalink,omitempty"`
-	Baseurl  string   `json:"baseurl,omitempty"`
-	Arch     string   `json:"arch"`
-	Mirrors  []string `json:"mirrors,omitempty"`
-	GPGKey   string   `json:"gpgkey,omitempty"`
+	Name       string   `json:"name"`
+	Disabled   bool     `json:"disabled,omitempty"`
+	Metalink   string   `json:"metalink,omitempty"`
+	Mirrorlist string   `json:"mirrorlist,omitempty"`
+	Baseurl    string   `json:"baseurl,omitempty"`
+	Arch       string   `json:"arch"`
+	Mirrors    []string `json:"mirrors,omitempty"`
+	GPGKey     string   `json:"gpgkey,omitempty"`
+	// PreferredMirrors are always listed first in the generated rpm rules, e.g. an artifact cache
+	PreferredMirrors []string `json:"preferredMirrors,omitempty"`
+	// MirrorCount limits the number of mirrors taken from a metalink or a mirrorlist
+	MirrorCount int `json:"mirrorCount,omitempty"`
+	// Locations are country codes of metalink mirrors which should be preferred, in order
+	Locations []string `json:"locations,omitempty"`
+	// Priority lets packages of this repository shadow packages with the same name from repositories with a
+	// higher priority value
+	Priority int `json:"priority,omitempty"`
+	// Cost decides which repository is used if the same package is available in repositories of the same priority
+	Cost int `json:"cost,omitempty"`
+	// ExcludePkgs hides all packages of this repository which match one of the patterns
+	ExcludePkgs []string `json:"excludepkgs,omitempty"`
+	// IncludePkgs hides all packages of this repository which don't match one of the patterns
+	IncludePkgs []string `json:"includepkgs,omitempty"`
+	// Directory points to a local directory of RPMs which are read directly instead of fetching repository metadata
+	Directory string `json:"directory,omitempty"`
+	// SSLCACert is a PEM file with additional certificate authorities which are trusted for this repository
+	SSLCACert string `json:"sslcacert,omitempty"`
+	// SSLClientCert and SSLClientKey are PEM files with the TLS client certificate used for this repository
+	SSLClientCert string `json:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty"`
+	// Username and Password are used for basic authentication, environment variables like ${TOKEN} are expanded
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
 }
@@ -0,0 +1,57 @@
+package api
+
+import "encoding/json"
+
+const (
+	ModulemdDocument         = "modulemd"
+	ModulemdDefaultsDocument = "modulemd-defaults"
+)
+
+// ModuleDocument is a single document of the modules.yaml metadata. Which data it contains depends on the document
+// type.
+type ModuleDocument struct {
+	Document string          `json:"document"`
+	Version  int             `json:"version"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// ModuleStream describes a build of a module stream and the packages which belong to it
+type ModuleStream struct {
+	Name      ModuleString `json:"name"`
+	Stream    ModuleString `json:"stream"`
+	Version   int64        `json:"version"`
+	Context   ModuleString `json:"context"`
+	Arch      ModuleString `json:"arch"`
+	Artifacts struct {
+		RPMs []string `json:"rpms"`
+	} `json:"artifacts"`
+}
+
+// ModuleDefaults describes which stream of a module is enabled by default
+type ModuleDefaults struct {
+	Module ModuleString `json:"module"`
+	Stream ModuleString `json:"stream"`
+}
+
+// Modules contains the module streams and module defaults of one or more repositories
+type Modules struct {
+	Streams  []ModuleStream
+	Defaults []ModuleDefaults
+}
+
+// ModuleString is a string in the modules metadata which may be written as a YAML number, like stream 8 of a module
+type ModuleString string
+
+func (s *ModuleString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = ModuleString(str)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*s = ModuleString(number.String())
+	return nil
+}
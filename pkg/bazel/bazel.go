@@ -3,6 +3,7 @@ package bazel
 import (
 	"fmt"
 	"io/ioutil"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -72,7 +73,7 @@ func AddRPMs(workspace *build.File, pkgs []*api.Package, arch string) {
 	}
 
 	for _, pkg := range pkgs {
-		pkgName := sanitize(pkg.String() + "." + arch)
+		pkgName := rpmName(pkg, arch)
 		rule := rpms[pkgName]
 		if rule == nil {
 			call := &build.CallExpr{X: &build.Ident{Name: "rpm"}}
@@ -165,7 +166,7 @@ func AddTree(name string, buildfile *build.File, pkgs []*api.Package, arch strin
 
 	rpms := []string{}
 	for _, pkg := range pkgs {
-		pkgName := sanitize(pkg.String() + "." + arch)
+		pkgName := rpmName(pkg, arch)
 		rpms = append(rpms, "@"+pkgName+"//rpm")
 	}
 	sort.SliceStable(rpms, func(i, j int) bool {
@@ -240,6 +241,29 @@ func (r *RPMRule) SetURLs(urls []string, href string) {
 	r.Rule.SetAttr("urls", &build.ListExpr{List: urlsAttr})
 }
 
+// AddURLTemplate lists the URL which results from the template in front of the existing URLs of the rule.
+// The placeholders {sha256}, {name} and {filename} are replaced with the sha256 sum, the name of the rule and the
+// file name of the RPM.
+func (r *RPMRule) AddURLTemplate(template string) {
+	filename := ""
+	if urls := r.URLs(); len(urls) > 0 {
+		filename = path.Base(urls[0])
+	}
+	url := strings.NewReplacer(
+		"{sha256}", r.SHA256(),
+		"{name}", r.Name(),
+		"{filename}", filename,
+	).Replace(template)
+
+	urlsAttr := []build.Expr{&build.StringExpr{Value: url}}
+	for _, existing := range r.URLs() {
+		if existing != url {
+			urlsAttr = append(urlsAttr, &build.StringExpr{Value: existing})
+		}
+	}
+	r.Rule.SetAttr("urls", &build.ListExpr{List: urlsAttr})
+}
+
 func (r *RPMRule) SetName(name string) {
 	r.Rule.SetAttr("name", &build.StringExpr{Value: name})
 }
@@ -305,6 +329,15 @@ func (r *tar2Files) SetFiles(dirs []string, fileMap map[string][]string) {
 	r.Rule.SetAttr("files", filesMapExpr)
 }
 
+// rpmName returns the name of the rpm rule for a package. noarch packages are named after the target architecture,
+// packages of other architectures like multilib packages after their own one.
+func rpmName(pkg *api.Package, arch string) string {
+	if pkg.Arch != "" && pkg.Arch != "noarch" {
+		arch = pkg.Arch
+	}
+	return sanitize(pkg.String() + "." + arch)
+}
+
 func sanitize(name string) string {
 	name = strings.ReplaceAll(name, ":", "__")
 	name = strings.ReplaceAll(name, "+", "__plus__")
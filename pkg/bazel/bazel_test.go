@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/bazelbuild/buildtools/build"
 	. "github.com/onsi/gomega"
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
@@ -90,6 +92,69 @@ func TestBuildfileWithRPMs(t *testing.T) {
 	}
 }
 
+func TestRPMName(t *testing.T) {
+	withArch := func(pkg *api.Package, arch string) *api.Package {
+		pkg.Arch = arch
+		return pkg
+	}
+	tests := []struct {
+		name     string
+		pkg      *api.Package
+		expected string
+	}{
+		{name: "should use the target architecture", pkg: withArch(newPkg("a", "1", nil), "x86_64"), expected: "a-0__1.x86_64"},
+		{name: "should use the target architecture for noarch packages", pkg: withArch(newPkg("a", "1", nil), "noarch"), expected: "a-0__1.x86_64"},
+		{name: "should use the package architecture for multilib packages", pkg: withArch(newPkg("a", "1", nil), "i686"), expected: "a-0__1.i686"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			g.Expect(rpmName(tt.pkg, "x86_64")).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestAddURLTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		urls     []string
+		expected []string
+	}{
+		{
+			name:     "should add the content-addressed URL first",
+			template: "https://cache.internal/{sha256}",
+			urls:     []string{"https://a/something/a.rpm", "https://b/something/a.rpm"},
+			expected: []string{"https://cache.internal/1234", "https://a/something/a.rpm", "https://b/something/a.rpm"},
+		},
+		{
+			name:     "should replace the name and the file name",
+			template: "https://cache.internal/{name}/{filename}",
+			urls:     []string{"https://a/something/a.rpm"},
+			expected: []string{"https://cache.internal/a-0__1.x86_64/a.rpm", "https://a/something/a.rpm"},
+		},
+		{
+			name:     "should not list the URL twice",
+			template: "https://cache.internal/{sha256}",
+			urls:     []string{"https://a/something/a.rpm", "https://cache.internal/1234"},
+			expected: []string{"https://cache.internal/1234", "https://a/something/a.rpm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			content := fmt.Sprintf(`rpm(name = "a-0__1.x86_64", sha256 = "1234", urls = ["%s"])`, strings.Join(tt.urls, `", "`))
+			file, err := build.ParseWorkspace("WORKSPACE", []byte(content))
+			g.Expect(err).ToNot(HaveOccurred())
+			rpms := GetRPMs(file)
+			g.Expect(rpms).To(HaveLen(1))
+
+			rpms[0].AddURLTemplate(tt.template)
+			g.Expect(rpms[0].URLs()).To(Equal(tt.expected))
+		})
+	}
+}
+
 func newPkg(name string, version string, repository *bazeldnf.Repository) *api.Package {
 	pkg := &api.Package{}
 	pkg.Name = name
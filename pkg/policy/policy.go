@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
+)
+
+// Policy restricts which packages may be picked during dependency resolution. The zero value allows every package.
+type Policy struct {
+	excludes []string
+	locks    map[string]api.Version
+}
+
+// New validates the exclude patterns and parses the locks, which have to be in the form name=[epoch:]version[-release].
+// Exclude patterns are matched against package names and support the syntax of path.Match.
+func New(excludes []string, locks []string) (*Policy, error) {
+	p := &Policy{locks: map[string]api.Version{}}
+	for _, pattern := range excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+		p.excludes = append(p.excludes, pattern)
+	}
+	for _, lock := range locks {
+		i := strings.Index(lock, "=")
+		if i <= 0 || i == len(lock)-1 {
+			return nil, fmt.Errorf("invalid lock %q, expected name=[epoch:]version[-release]", lock)
+		}
+		name := lock[:i]
+		if _, exists := p.locks[name]; exists {
+			return nil, fmt.Errorf("package %s is locked more than once", name)
+		}
+		version, err := rpm.ParseEVR(lock[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lock %q: %v", lock, err)
+		}
+		p.locks[name] = version
+	}
+	return p, nil
+}
+
+// Reason returns why a package must not be installed, or an empty string if the package is allowed
+func (p *Policy) Reason(pkg *api.Package) string {
+	for _, pattern := range p.excludes {
+		if matched, _ := path.Match(pattern, pkg.Name); matched {
+			return fmt.Sprintf("%s is excluded by %q", pkg.String(), pattern)
+		}
+	}
+	if lock, exists := p.locks[pkg.Name]; exists && !matchesLock(pkg.Version, lock) {
+		return fmt.Sprintf("%s is not allowed since %s is locked to %s", pkg.String(), pkg.Name, lock.String())
+	}
+	return ""
+}
+
+// Allows returns true if the package may be installed
+func (p *Policy) Allows(pkg *api.Package) bool {
+	return p.Reason(pkg) == ""
+}
+
+// matchesLock compares a package version with a lock. A lock without release matches all releases.
+func matchesLock(version api.Version, lock api.Version) bool {
+	if version.Epoch == "" {
+		version.Epoch = "0"
+	}
+	if lock.Rel == "" {
+		version.Rel = ""
+	}
+	return rpm.Compare(version, lock) == 0
+}
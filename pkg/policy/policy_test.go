@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		locks    []string
+		pkg      string
+		version  api.Version
+		allowed  bool
+	}{
+		{name: "should allow everything by default", pkg: "systemd", version: api.Version{Ver: "245"}, allowed: true},
+		{name: "should exclude exact names", excludes: []string{"systemd"}, pkg: "systemd", version: api.Version{Ver: "245"}, allowed: false},
+		{name: "should exclude glob patterns", excludes: []string{"systemd*"}, pkg: "systemd-libs", version: api.Version{Ver: "245"}, allowed: false},
+		{name: "should not exclude other packages", excludes: []string{"systemd*"}, pkg: "glibc", version: api.Version{Ver: "2.32"}, allowed: true},
+		{name: "should allow the locked version", locks: []string{"glibc=2.32-4"}, pkg: "glibc", version: api.Version{Ver: "2.32", Rel: "4"}, allowed: true},
+		{name: "should allow the locked version with an explicit epoch", locks: []string{"glibc=0:2.32-4"}, pkg: "glibc", version: api.Version{Epoch: "0", Ver: "2.32", Rel: "4"}, allowed: true},
+		{name: "should allow all releases if the lock has none", locks: []string{"glibc=2.32"}, pkg: "glibc", version: api.Version{Ver: "2.32", Rel: "7"}, allowed: true},
+		{name: "should reject other versions", locks: []string{"glibc=2.32-4"}, pkg: "glibc", version: api.Version{Ver: "2.33", Rel: "1"}, allowed: false},
+		{name: "should reject other epochs", locks: []string{"glibc=1:2.32-4"}, pkg: "glibc", version: api.Version{Ver: "2.32", Rel: "4"}, allowed: false},
+		{name: "should not affect other packages", locks: []string{"glibc=2.32-4"}, pkg: "bash", version: api.Version{Ver: "5.0"}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			p, err := New(tt.excludes, tt.locks)
+			g.Expect(err).ToNot(HaveOccurred())
+			pkg := &api.Package{Name: tt.pkg, Version: tt.version}
+			g.Expect(p.Allows(pkg)).To(Equal(tt.allowed))
+			if tt.allowed {
+				g.Expect(p.Reason(pkg)).To(BeEmpty())
+			} else {
+				g.Expect(p.Reason(pkg)).ToNot(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		locks    []string
+	}{
+		{name: "should fail on invalid patterns", excludes: []string{"systemd["}},
+		{name: "should fail on locks without version", locks: []string{"glibc="}},
+		{name: "should fail on locks without name", locks: []string{"=2.32"}},
+		{name: "should fail on locks without separator", locks: []string{"glibc"}},
+		{name: "should fail on locks with an invalid epoch", locks: []string{"glibc=x:2.32"}},
+		{name: "should fail on locks with an empty release", locks: []string{"glibc=2.32-"}},
+		{name: "should fail on duplicate locks", locks: []string{"glibc=2.32", "glibc=2.33"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			_, err := New(tt.excludes, tt.locks)
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect((&Policy{}).Allows(&api.Package{Name: "bash"})).To(BeTrue())
+}
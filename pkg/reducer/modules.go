@@ -0,0 +1,105 @@
+package reducer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/sirupsen/logrus"
+)
+
+// enabledStreams returns the enabled stream of every module. Default streams are enabled unless another stream of
+// the module is enabled explicitly in the form name:stream.
+func enabledStreams(modules *api.Modules, enable []string) (map[string]string, error) {
+	streams := map[string]map[string]bool{}
+	for _, stream := range modules.Streams {
+		if streams[string(stream.Name)] == nil {
+			streams[string(stream.Name)] = map[string]bool{}
+		}
+		streams[string(stream.Name)][string(stream.Stream)] = true
+	}
+	enabled := map[string]string{}
+	for _, defaults := range modules.Defaults {
+		if defaults.Stream != "" {
+			enabled[string(defaults.Module)] = string(defaults.Stream)
+		}
+	}
+	for _, module := range enable {
+		fields := strings.SplitN(module, ":", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid module %q, expected name:stream", module)
+		}
+		if streams[fields[0]] == nil {
+			return nil, fmt.Errorf("module %s does not exist", fields[0])
+		}
+		if !streams[fields[0]][fields[1]] {
+			known := []string{}
+			for stream := range streams[fields[0]] {
+				known = append(known, stream)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("module %s has no stream %s, available streams are %s", fields[0], fields[1], strings.Join(known, ", "))
+		}
+		enabled[fields[0]] = fields[1]
+	}
+	return enabled, nil
+}
+
+// filterModules hides packages like dnf does for modular repositories. Packages which belong to a module stream are
+// only visible if the stream is enabled, and packages of enabled streams hide all non-modular packages with the same
+// name.
+func filterModules(packages []api.Package, modules *api.Modules, enable []string) ([]api.Package, error) {
+	enabled, err := enabledStreams(modules, enable)
+	if err != nil {
+		return nil, err
+	}
+	if len(modules.Streams) == 0 {
+		return packages, nil
+	}
+	names := []string{}
+	for name := range enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logrus.Infof("Enabling module stream %s:%s", name, enabled[name])
+	}
+	// artifacts maps the NEVRA of every modular package to whether its stream is enabled
+	artifacts := map[string]bool{}
+	shadowed := map[string]bool{}
+	for _, stream := range modules.Streams {
+		isEnabled := enabled[string(stream.Name)] == string(stream.Stream)
+		for _, rpm := range stream.Artifacts.RPMs {
+			artifacts[rpm] = artifacts[rpm] || isEnabled
+			if isEnabled {
+				shadowed[artifactName(rpm)] = true
+			}
+		}
+	}
+	filtered := []api.Package{}
+	for i, p := range packages {
+		isEnabled, modular := artifacts[p.String()+"."+p.Arch]
+		if modular && !isEnabled {
+			logrus.Debugf("Hiding %s.%s since its module stream is not enabled", p.String(), p.Arch)
+			continue
+		}
+		if !modular && shadowed[p.Name] {
+			logrus.Debugf("Hiding %s.%s since an enabled module stream provides %s", p.String(), p.Arch, p.Name)
+			continue
+		}
+		filtered = append(filtered, packages[i])
+	}
+	logrus.Infof("Hid %d packages because of module streams.", len(packages)-len(filtered))
+	return filtered, nil
+}
+
+// artifactName returns the package name of a NEVRA like nodejs-1:10.24.0-1.module_el8.x86_64
+func artifactName(nevra string) string {
+	for i := 0; i < 2; i++ {
+		if j := strings.LastIndex(nevra, "-"); j > 0 {
+			nevra = nevra[:j]
+		}
+	}
+	return nevra
+}
@@ -0,0 +1,63 @@
+package reducer
+
+import (
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/sirupsen/logrus"
+)
+
+// filterPriorities hides packages which are shadowed by packages of the same name and architecture from a
+// repository with a higher priority, like dnf does. If the very same package is available in multiple repositories
+// of the same priority, only the one from the repository with the lowest cost is kept.
+func filterPriorities(packages []api.Package) []api.Package {
+	type nameArch struct{ name, arch string }
+	best := map[nameArch]int{}
+	for _, p := range packages {
+		key := nameArch{p.Name, p.Arch}
+		if priority, exists := best[key]; !exists || repoPriority(&p) < priority {
+			best[key] = repoPriority(&p)
+		}
+	}
+	cheapest := map[string]*api.Package{}
+	for i, p := range packages {
+		if repoPriority(&p) != best[nameArch{p.Name, p.Arch}] {
+			continue
+		}
+		key := p.String() + "." + p.Arch
+		if other := cheapest[key]; other == nil || repoCost(&p) < repoCost(other) {
+			cheapest[key] = &packages[i]
+		}
+	}
+
+	filtered := []api.Package{}
+	for i, p := range packages {
+		key := p.String() + "." + p.Arch
+		if priority := best[nameArch{p.Name, p.Arch}]; repoPriority(&p) != priority {
+			logrus.Debugf("Hiding %s.%s from %s since a repository with priority %d provides %s.%s", p.String(), p.Arch, p.RepositoryName(), priority, p.Name, p.Arch)
+			continue
+		}
+		if other := cheapest[key]; other != &packages[i] {
+			logrus.Debugf("Hiding %s.%s from %s since %s provides it at a lower cost", p.String(), p.Arch, p.RepositoryName(), other.RepositoryName())
+			continue
+		}
+		filtered = append(filtered, packages[i])
+	}
+	if hidden := len(packages) - len(filtered); hidden > 0 {
+		logrus.Infof("Hid %d packages because of repository priorities.", hidden)
+	}
+	return filtered
+}
+
+func repoPriority(p *api.Package) int {
+	if p.Repository == nil || p.Repository.Priority == 0 {
+		return bazeldnf.DefaultPriority
+	}
+	return p.Repository.Priority
+}
+
+func repoCost(p *api.Package) int {
+	if p.Repository == nil || p.Repository.Cost == 0 {
+		return bazeldnf.DefaultCost
+	}
+	return p.Repository.Cost
+}
@@ -4,24 +4,36 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/policy"
 	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/rmohr/bazeldnf/pkg/richdep"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
 	"github.com/sirupsen/logrus"
 )
 
 type RepoReducer struct {
 	packages         []api.Package
-	lang             string
+	languages        []string
 	repoFiles        []string
 	provides         map[string][]*api.Package
+	names            map[string][]*api.Package
 	implicitRequires []string
 	arch             string
 	architectures    []string
 	repos            *bazeldnf.Repositories
 	cacheHelper      *repo.CacheHelper
+	filelists        bool
+	weakDeps         bool
+	modules          []string
+	supplements      map[string][]*api.Package
+	obsoletes        map[string][]*api.Package
+	policy           *policy.Policy
 }
 
 func (r *RepoReducer) Load() error {
@@ -56,21 +68,108 @@ func (r *RepoReducer) Load() error {
 		}
 	}
 
-	for i, p := range r.packages {
-		requires := []api.Entry{}
-		for _, requirement := range p.Format.Requires.Entries {
-			if !strings.HasPrefix(requirement.Name, "(") {
-				requires = append(requires, requirement)
-			}
+	r.packages = filterRepositoryPackages(r.packages)
+
+	modules, err := r.cacheHelper.CurrentModulesForRepos(r.repos, r.arch)
+	if err != nil {
+		return err
+	}
+	r.packages, err = filterModules(r.packages, modules, r.modules)
+	if err != nil {
+		return err
+	}
+	r.packages = filterPriorities(r.packages)
+
+	if r.filelists {
+		if err := r.loadFilelists(); err != nil {
+			return err
 		}
-		r.packages[i].Format.Requires.Entries = requires
+	}
 
+	r.indexPackages()
+	return nil
+}
+
+// indexPackages indexes the loaded packages by name, by provided resources and by the packages they obsolete or
+// supplement
+func (r *RepoReducer) indexPackages() {
+	for i, p := range r.packages {
+		r.names[p.Name] = append(r.names[p.Name], &r.packages[i])
 		for _, provides := range p.Format.Provides.Entries {
 			r.provides[provides.Name] = append(r.provides[provides.Name], &r.packages[i])
 		}
 		for _, file := range p.Format.Files {
 			r.provides[file.Text] = append(r.provides[file.Text], &r.packages[i])
 		}
+		for _, obsoletes := range p.Format.Obsoletes.Entries {
+			r.obsoletes[obsoletes.Name] = append(r.obsoletes[obsoletes.Name], &r.packages[i])
+		}
+		if r.weakDeps {
+			for _, supplements := range r.expandDependencies(&r.packages[i], p.Format.Supplements.Entries) {
+				r.supplements[supplements.Name] = append(r.supplements[supplements.Name], &r.packages[i])
+			}
+		}
+	}
+}
+
+// loadFilelists adds all files which are required by any package, but are not part of the primary metadata, from
+// the filelists metadata to the providing packages.
+func (r *RepoReducer) loadFilelists() error {
+	required := map[string]struct{}{}
+	for i := range r.packages {
+		for _, req := range r.expandRequires(&r.packages[i]) {
+			if strings.HasPrefix(req.Name, "/") {
+				required[req.Name] = struct{}{}
+			}
+		}
+	}
+	for _, p := range r.packages {
+		for _, file := range p.Format.Files {
+			delete(required, file.Text)
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	logrus.Infof("Looking up %d required files in the filelists.", len(required))
+
+	repos := []*bazeldnf.Repository{}
+	candidates := map[*bazeldnf.Repository][]*api.Package{}
+	index := map[string]*api.Package{}
+	for i, p := range r.packages {
+		// local repositories already list all files
+		if p.Repository == nil || p.Repository.Directory != "" {
+			continue
+		}
+		if _, exists := candidates[p.Repository]; !exists {
+			repos = append(repos, p.Repository)
+		}
+		candidates[p.Repository] = append(candidates[p.Repository], &r.packages[i])
+		index[p.String()+"."+p.Arch] = &r.packages[i]
+	}
+
+	for _, rpmrepo := range repos {
+		filelists, remaining, err := r.cacheHelper.CurrentFilelistsForPackages(rpmrepo, r.architectures, candidates[rpmrepo])
+		if err != nil {
+			return err
+		}
+		if len(remaining) > 0 {
+			logrus.Debugf("No filelists found for %d packages of %s", len(remaining), rpmrepo.Name)
+		}
+		for _, filelist := range filelists {
+			pkg := index[filelist.String()+"."+filelist.Arch]
+			if pkg == nil {
+				continue
+			}
+			for _, file := range filelist.File {
+				if _, exists := required[file.Text]; !exists {
+					continue
+				}
+				if !hasFile(pkg, file.Text) {
+					pkg.Format.Files = append(pkg.Format.Files, file)
+				}
+			}
+		}
 	}
 	return nil
 }
@@ -79,32 +178,45 @@ func (r *RepoReducer) Resolve(packages []string) (matched []string, involved []*
 	packages = append(packages, r.implicitRequires...)
 	discovered := map[string]*api.Package{}
 	for _, req := range packages {
-		found := false
-		name := ""
-		var candidates []*api.Package
-		for i, p := range r.packages {
-			if strings.HasPrefix(p.String(), req) {
-				if strings.HasPrefix(req, p.Name) {
-					if !found || len(p.Name) < len(name) {
-						candidates = []*api.Package{&r.packages[i]}
-						name = p.Name
-						found = true
-					} else if p.Name == name {
-						candidates = append(candidates, &r.packages[i])
-					}
-				}
+		candidates, err := r.lookup(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates = r.preferPrimaryArch(candidates)
+		allowed := []*api.Package{}
+		reasons := []string{}
+		for _, p := range candidates {
+			if reason := r.policy.Reason(p); reason != "" {
+				reasons = append(reasons, reason)
+			} else {
+				allowed = append(allowed, p)
 			}
 		}
-		if !found {
-			return nil, nil, fmt.Errorf("Package %s does not exist", req)
+		if len(allowed) == 0 {
+			return nil, nil, fmt.Errorf("Package %s can't be selected: %s", req, strings.Join(reasons, "; "))
 		}
+		candidates = allowed
+		names := []string{}
+		seen := map[string]bool{}
 		for i, p := range candidates {
-			discovered[p.String()] = candidates[i]
-		}
-
-		if len(candidates) > 0 {
-			matched = append(matched, candidates[0].Name)
+			// packages of other architectures have to be selected explicitly
+			name := p.Name
+			if p.Arch != "noarch" && p.Arch != r.arch {
+				name = p.Name + "." + p.Arch
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			discovered[p.String()+"."+p.Arch] = candidates[i]
+			// the resolver prefers packages which replace the requested ones
+			for _, obsoleting := range r.obsoletes[p.Name] {
+				if obsoleting.Name != p.Name && r.policy.Allows(obsoleting) {
+					discovered[obsoleting.String()+"."+obsoleting.Arch] = obsoleting
+				}
+			}
 		}
+		matched = append(matched, names...)
 	}
 
 	for {
@@ -113,9 +225,15 @@ func (r *RepoReducer) Resolve(packages []string) (matched []string, involved []*
 			current = append(current, k)
 		}
 		for _, p := range current {
+			if !r.policy.Allows(discovered[p]) {
+				// packages which can't be installed don't pull in anything, but the resolver still has to know them
+				// to explain why they can't be picked
+				continue
+			}
 			for _, newFound := range r.requires(discovered[p]) {
-				if _, exists := discovered[newFound.String()]; !exists {
-					discovered[newFound.String()] = newFound
+				key := newFound.String() + "." + newFound.Arch
+				if _, exists := discovered[key]; !exists {
+					discovered[key] = newFound
 				}
 			}
 		}
@@ -124,14 +242,227 @@ func (r *RepoReducer) Resolve(packages []string) (matched []string, involved []*
 		}
 	}
 
-	for i, _ := range discovered {
-		involved = append(involved, discovered[i])
+	// return the packages in a stable order to get reproducible results
+	keys := []string{}
+	for k := range discovered {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		involved = append(involved, discovered[k])
 	}
 	return matched, involved, nil
 }
 
+// preferPrimaryArch drops multilib packages if packages of the primary architecture match too, like dnf does
+func (r *RepoReducer) preferPrimaryArch(candidates []*api.Package) []*api.Package {
+	primary := []*api.Package{}
+	for _, p := range candidates {
+		if p.Arch == "noarch" || p.Arch == r.arch {
+			primary = append(primary, p)
+		}
+	}
+	if len(primary) == 0 {
+		return candidates
+	}
+	return primary
+}
+
+// packageSpec is one way to read a requested package, like name.arch or name-version-release
+type packageSpec struct {
+	name    string
+	epoch   string
+	version string
+	release string
+	arch    string
+}
+
+// parseSpecs returns all ways to read a requested package in the order in which dnf tries them:
+// name, name.arch, name-[epoch:]version, name-[epoch:]version-release and name-[epoch:]version-release.arch
+func parseSpecs(req string) (specs []packageSpec) {
+	specs = append(specs, packageSpec{name: req})
+	if i := strings.LastIndex(req, "."); i > 0 {
+		specs = append(specs, packageSpec{name: req[:i], arch: req[i+1:]})
+	}
+	withVersion := func(nevr string, arch string) {
+		i := strings.LastIndex(nevr, "-")
+		if i <= 0 {
+			return
+		}
+		name, version := nevr[:i], nevr[i+1:]
+		if arch == "" {
+			specs = append(specs, withEpoch(packageSpec{name: name, version: version}))
+		}
+		if j := strings.LastIndex(name, "-"); j > 0 {
+			specs = append(specs, withEpoch(packageSpec{name: name[:j], version: name[j+1:], release: version, arch: arch}))
+		}
+	}
+	withVersion(req, "")
+	if i := strings.LastIndex(req, "."); i > 0 {
+		withVersion(req[:i], req[i+1:])
+	}
+	return specs
+}
+
+func withEpoch(spec packageSpec) packageSpec {
+	if i := strings.Index(spec.version, ":"); i >= 0 {
+		spec.epoch, spec.version = spec.version[:i], spec.version[i+1:]
+	}
+	return spec
+}
+
+// matches checks if a package fits the spec. All fields except the epoch may contain glob patterns.
+func (s packageSpec) matches(p *api.Package) bool {
+	if s.epoch != "" {
+		epoch := p.Version.Epoch
+		if epoch == "" {
+			epoch = "0"
+		}
+		if epoch != s.epoch {
+			return false
+		}
+	}
+	return matchField(s.version, p.Version.Ver) && matchField(s.release, p.Version.Rel) && matchField(s.arch, p.Arch)
+}
+
+func matchField(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+// matchesPattern checks if a package matches a dnf style pattern like the ones of the excludepkgs and includepkgs
+// repository options
+func matchesPattern(pattern string, p *api.Package) bool {
+	for _, spec := range parseSpecs(pattern) {
+		if matchField(spec.name, p.Name) && spec.matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRepositoryPackages applies the excludepkgs and includepkgs patterns of the repositories to their packages
+func filterRepositoryPackages(packages []api.Package) (filtered []api.Package) {
+	for i, p := range packages {
+		if p.Repository == nil {
+			filtered = append(filtered, packages[i])
+			continue
+		}
+		excluded := false
+		for _, pattern := range p.Repository.ExcludePkgs {
+			if matchesPattern(pattern, &p) {
+				logrus.Debugf("Hiding %s.%s since %s excludes %s", p.String(), p.Arch, p.Repository.Name, pattern)
+				excluded = true
+				break
+			}
+		}
+		if !excluded && len(p.Repository.IncludePkgs) > 0 {
+			excluded = true
+			for _, pattern := range p.Repository.IncludePkgs {
+				if matchesPattern(pattern, &p) {
+					excluded = false
+					break
+				}
+			}
+			if excluded {
+				logrus.Debugf("Hiding %s.%s since %s does not include it", p.String(), p.Arch, p.Repository.Name)
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, packages[i])
+		}
+	}
+	if hidden := len(packages) - len(filtered); hidden > 0 {
+		logrus.Infof("Hid %d packages because of repository exclude and include patterns.", hidden)
+	}
+	return filtered
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// lookup finds all packages matching a requested package. A package whose name matches exactly is always taken.
+// Patterns are read like dnf does, the first way to read them which matches any package wins. Other requests may
+// only be read in one way, or they are ambiguous.
+func (r *RepoReducer) lookup(req string) ([]*api.Package, error) {
+	if candidates := r.names[req]; len(candidates) > 0 {
+		return candidates, nil
+	}
+	var found []*api.Package
+	for _, spec := range parseSpecs(req) {
+		candidates := []*api.Package{}
+		for _, name := range r.matchNames(spec.name) {
+			for _, p := range r.names[name] {
+				if spec.matches(p) {
+					candidates = append(candidates, p)
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("Package %s is ambiguous, it can match %s", req, describeCandidates(append(found, candidates...)))
+		}
+		found = candidates
+		if isGlob(req) {
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Package %s does not exist", req)
+	}
+	for _, p := range found {
+		if p.Name != found[0].Name {
+			logrus.Infof("Package %s matches %s", req, describeCandidates(found))
+			break
+		}
+	}
+	return found, nil
+}
+
+// matchNames returns all known package names which match a name or a glob pattern
+func (r *RepoReducer) matchNames(pattern string) (names []string) {
+	if !isGlob(pattern) {
+		if _, exists := r.names[pattern]; exists {
+			names = append(names, pattern)
+		}
+		return names
+	}
+	for name := range r.names {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describeCandidates lists packages with their architecture in a stable order
+func describeCandidates(packages []*api.Package) string {
+	descriptions := []string{}
+	seen := map[string]bool{}
+	for _, p := range packages {
+		description := p.String() + "." + p.Arch
+		if !seen[description] {
+			seen[description] = true
+			descriptions = append(descriptions, description)
+		}
+	}
+	sort.Strings(descriptions)
+	return strings.Join(descriptions, ", ")
+}
+
 func (r *RepoReducer) requires(p *api.Package) (wants []*api.Package) {
-	for _, requires := range p.Format.Requires.Entries {
+	dependencies := r.expandRequires(p)
+	if r.weakDeps {
+		dependencies = append(dependencies, r.expandDependencies(p, p.Format.Recommends.Entries)...)
+	}
+	for _, requires := range dependencies {
 		if val, exists := r.provides[requires.Name]; exists {
 
 			var packages []string
@@ -139,25 +470,163 @@ func (r *RepoReducer) requires(p *api.Package) (wants []*api.Package) {
 				packages = append(packages, p.Name)
 			}
 			logrus.Debugf("%s wants %v because of %v\n", p.Name, packages, requires)
+			// language packs are only dropped if something else can satisfy the requirement
+			if filtered := r.filterLangpacks(val); len(filtered) > 0 {
+				val = filtered
+			}
 			wants = append(wants, val...)
 		} else {
 			logrus.Debugf("%s requires %v which can't be satisfied\n", p.Name, requires)
 		}
 	}
+	if r.weakDeps {
+		for _, provides := range p.Format.Provides.Entries {
+			wants = append(wants, r.filterLangpacks(r.supplements[provides.Name])...)
+		}
+	}
 	return wants
 }
 
-func NewRepoReducer(repos *bazeldnf.Repositories, repoFiles []string, lang string, fedoraRelease string, arch string, cachDir string) *RepoReducer {
+// filterLangpacks drops language packs for languages which were not requested
+func (r *RepoReducer) filterLangpacks(packages []*api.Package) (filtered []*api.Package) {
+	for _, p := range packages {
+		if r.allowsLanguages(packageLanguages(p)) {
+			filtered = append(filtered, p)
+		} else {
+			logrus.Debugf("Dropping language pack %s", p.String())
+		}
+	}
+	return filtered
+}
+
+// allowsLanguages checks if one of the given languages was requested. Packages without languages are always allowed.
+func (r *RepoReducer) allowsLanguages(languages []string) bool {
+	if len(languages) == 0 {
+		return true
+	}
+	for _, language := range languages {
+		for _, requested := range r.languages {
+			if language == requested || language == baseLanguage(requested) || baseLanguage(language) == requested {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// packageLanguages returns the languages a package is a language pack for, based on names like
+// glibc-langpack-de, langpacks-de or langpacks-core-de and on supplements of such names
+func packageLanguages(p *api.Package) (languages []string) {
+	if language := langpackLanguage(p.Name); language != "" {
+		languages = append(languages, language)
+	}
+	for _, supplements := range p.Format.Supplements.Entries {
+		names := []string{supplements.Name}
+		if richdep.IsRich(supplements.Name) {
+			dep, err := richdep.Parse(supplements.Name)
+			if err != nil {
+				continue
+			}
+			names = nil
+			for _, candidate := range dep.Candidates() {
+				names = append(names, candidate.Name)
+			}
+		}
+		for _, name := range names {
+			if language := langpackLanguage(name); language != "" {
+				languages = append(languages, language)
+			}
+		}
+	}
+	return languages
+}
+
+func langpackLanguage(name string) string {
+	if !strings.HasPrefix(name, "langpacks-") && !strings.Contains(name, "-langpack-") {
+		return ""
+	}
+	return name[strings.LastIndex(name, "-")+1:]
+}
+
+// baseLanguage strips the territory, codeset and modifier from a locale like de_DE.UTF-8@euro
+func baseLanguage(locale string) string {
+	if i := strings.IndexAny(locale, "_.@"); i >= 0 {
+		return locale[:i]
+	}
+	return locale
+}
+
+func splitLanguages(lang string) (languages []string) {
+	for _, language := range strings.Split(lang, ",") {
+		if language = strings.TrimSpace(language); language != "" {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+// expandRequires replaces rich dependencies with all simple dependencies which may be needed to satisfy them
+func (r *RepoReducer) expandRequires(p *api.Package) (requires []api.Entry) {
+	return r.expandDependencies(p, p.Format.Requires.Entries)
+}
+
+// expandDependencies replaces rich dependencies with all simple dependencies they consist of
+func (r *RepoReducer) expandDependencies(p *api.Package, dependencies []api.Entry) (expanded []api.Entry) {
+	for _, dependency := range dependencies {
+		if !richdep.IsRich(dependency.Name) {
+			expanded = append(expanded, dependency)
+			continue
+		}
+		dep, err := richdep.Parse(dependency.Name)
+		if err != nil {
+			logrus.Warnf("%s has an invalid dependency: %v", p.Name, err)
+			continue
+		}
+		expanded = append(expanded, dep.Candidates()...)
+	}
+	return expanded
+}
+
+// Options are the optional features of a RepoReducer. The zero value keeps all language packs, only considers
+// the given architecture and ignores filelists, weak dependencies, modules and package policies.
+type Options struct {
+	// Lang is a comma separated list of locales whose language packs are kept
+	Lang string
+	// FedoraRelease is a package which is implicitly required by all other packages
+	FedoraRelease string
+	// Multilib also considers the packages of compatible architectures
+	Multilib bool
+	// Filelists resolves file requirements from the filelists metadata
+	Filelists bool
+	// WeakDeps follows recommended and supplementing packages
+	WeakDeps bool
+	// Modules are the enabled module streams
+	Modules []string
+	Policy  *policy.Policy
+}
+
+func NewRepoReducer(repos *bazeldnf.Repositories, repoFiles []string, arch string, cacheDir string, opts Options) *RepoReducer {
+	packagePolicy := opts.Policy
+	if packagePolicy == nil {
+		packagePolicy = &policy.Policy{}
+	}
 	return &RepoReducer{
 		packages:         nil,
-		lang:             lang,
-		implicitRequires: []string{fedoraRelease},
+		languages:        splitLanguages(opts.Lang),
+		implicitRequires: []string{opts.FedoraRelease},
 		repoFiles:        repoFiles,
 		provides:         map[string][]*api.Package{},
-		architectures:    []string{"noarch", arch},
+		names:            map[string][]*api.Package{},
+		architectures:    rpm.CompatibleArches(arch, opts.Multilib),
 		arch:             arch,
 		repos:            repos,
-		cacheHelper:      &repo.CacheHelper{CacheDir: cachDir},
+		cacheHelper:      &repo.CacheHelper{CacheDir: cacheDir},
+		filelists:        opts.Filelists,
+		weakDeps:         opts.WeakDeps,
+		modules:          opts.Modules,
+		supplements:      map[string][]*api.Package{},
+		obsoletes:        map[string][]*api.Package{},
+		policy:           packagePolicy,
 	}
 }
 
@@ -171,3 +640,12 @@ func skip(arch string, arches []string) bool {
 	}
 	return skip
 }
+
+func hasFile(pkg *api.Package, file string) bool {
+	for _, f := range pkg.Format.Files {
+		if f.Text == file {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,425 @@
+package reducer
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/policy"
+	"github.com/rmohr/bazeldnf/pkg/sat"
+)
+
+func TestFilelists(t *testing.T) {
+	tests := []struct {
+		name      string
+		filelists bool
+		involved  []string
+		solvable  bool
+	}{
+		{
+			name:      "should only know files from the primary metadata by default",
+			filelists: false,
+			involved:  []string{"a-0:1.0-1"},
+			solvable:  false,
+		},
+		{
+			name:      "should resolve required files from the filelists metadata",
+			filelists: true,
+			involved:  []string{"a-0:1.0-1", "b-0:2.0-1"},
+			solvable:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			repos := &bazeldnf.Repositories{Repositories: []bazeldnf.Repository{
+				{Name: "filelists", Arch: "x86_64", Baseurl: "http://filelists"},
+			}}
+			reducer := NewRepoReducer(repos, nil, "x86_64", "testdata/cache", Options{FedoraRelease: "a", Filelists: tt.filelists})
+			g.Expect(reducer.Load()).To(Succeed())
+			matched, involved, err := reducer.Resolve([]string{"a"})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(involved)).To(ConsistOf(tt.involved))
+
+			for _, p := range involved {
+				if p.Name == "b" {
+					// only files which are required by other packages get added
+					g.Expect(p.Format.Files).To(ConsistOf(
+						api.ProvidedFile{Text: "/usr/bin/b"},
+						api.ProvidedFile{Text: "/usr/libexec/b-helper"},
+					))
+				}
+			}
+
+			resolver := sat.NewResolver(false, sat.ObjectiveNone, false, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(involved)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements(matched)).To(Succeed())
+			install, _, err := resolver.Resolve()
+			if !tt.solvable {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(install)).To(ConsistOf(tt.involved))
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		excludes []string
+		locks    []string
+		involved []string
+		fail     bool
+	}{
+		{name: "should select requested packages by default", involved: []string{"a-0:1.0-1", "b-0:2.0-1"}},
+		{name: "should fail if a requested package is excluded", excludes: []string{"a"}, fail: true},
+		{name: "should fail if a requested package is not available in the locked version", locks: []string{"a=2.0"}, fail: true},
+		{name: "should keep excluded dependencies to explain why they can't be picked", excludes: []string{"b"}, involved: []string{"a-0:1.0-1", "b-0:2.0-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			repos := &bazeldnf.Repositories{Repositories: []bazeldnf.Repository{
+				{Name: "filelists", Arch: "x86_64", Baseurl: "http://filelists"},
+			}}
+			packagePolicy, err := policy.New(tt.excludes, tt.locks)
+			g.Expect(err).ToNot(HaveOccurred())
+			reducer := NewRepoReducer(repos, nil, "x86_64", "testdata/cache", Options{FedoraRelease: "a", Filelists: true, Policy: packagePolicy})
+			g.Expect(reducer.Load()).To(Succeed())
+			_, involved, err := reducer.Resolve([]string{"a"})
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(involved)).To(ConsistOf(tt.involved))
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	newPkg := func(name string, epoch string, version string, release string, arch string) api.Package {
+		p := api.Package{Name: name, Arch: arch}
+		p.Version = api.Version{Epoch: epoch, Ver: version, Rel: release}
+		return p
+	}
+	tests := []struct {
+		name    string
+		req     string
+		matches []string
+		fail    bool
+	}{
+		{name: "should match exact names", req: "foo", matches: []string{"foo-0:1.0-1", "foo-0:2.0-1", "foo-0:2.0-1"}},
+		{name: "should not match other packages with the same prefix", req: "foo-de", fail: true},
+		{name: "should match name and version", req: "foo-2.0", matches: []string{"foo-0:2.0-1", "foo-0:2.0-1"}},
+		{name: "should match name, version and release", req: "foo-1.0-1", matches: []string{"foo-0:1.0-1"}},
+		{name: "should match name and architecture", req: "foo.i686", matches: []string{"foo-0:2.0-1"}},
+		{name: "should match the full NEVRA", req: "foo-2.0-1.x86_64", matches: []string{"foo-0:2.0-1"}},
+		{name: "should match epochs", req: "bar-1:3.0-2", matches: []string{"bar-1:3.0-2"}},
+		{name: "should not match wrong epochs", req: "bar-2:3.0-2", fail: true},
+		{name: "should match name globs", req: "fo?", matches: []string{"foo-0:1.0-1", "foo-0:2.0-1", "foo-0:2.0-1"}},
+		{name: "should prefer globs on the name", req: "foo-*", matches: []string{"foo-devel-0:2.0-1"}},
+		{name: "should match version globs", req: "foo-2.*-1.i686", matches: []string{"foo-0:2.0-1"}},
+		{name: "should fail on ambiguous requests", req: "baz-1-2", fail: true},
+		{name: "should fail on unknown packages", req: "missing", fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			reducer := NewRepoReducer(&bazeldnf.Repositories{}, nil, "x86_64", "testdata/cache", Options{FedoraRelease: "a"})
+			reducer.packages = []api.Package{
+				newPkg("foo", "", "1.0", "1", "x86_64"),
+				newPkg("foo", "", "2.0", "1", "x86_64"),
+				newPkg("foo", "", "2.0", "1", "i686"),
+				newPkg("foo-devel", "", "2.0", "1", "x86_64"),
+				newPkg("bar", "1", "3.0", "2", "x86_64"),
+				newPkg("baz-1", "", "2", "1", "x86_64"),
+				newPkg("baz", "", "1", "2", "x86_64"),
+			}
+			reducer.indexPackages()
+			matches, err := reducer.lookup(tt.req)
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(matches)).To(ConsistOf(tt.matches))
+		})
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	newPkg := func(name string, provides []string, requires []string, supplements []string) api.Package {
+		p := api.Package{Name: name, Arch: "x86_64"}
+		p.Version = api.Version{Ver: "1"}
+		for _, entry := range append([]string{name}, provides...) {
+			p.Format.Provides.Entries = append(p.Format.Provides.Entries, api.Entry{Name: entry})
+		}
+		for _, entry := range requires {
+			p.Format.Requires.Entries = append(p.Format.Requires.Entries, api.Entry{Name: entry})
+		}
+		for _, entry := range supplements {
+			p.Format.Supplements.Entries = append(p.Format.Supplements.Entries, api.Entry{Name: entry})
+		}
+		return p
+	}
+	tests := []struct {
+		name     string
+		lang     string
+		requires []string
+		involved []string
+	}{
+		{name: "should drop all language packs by default", requires: []string{"app"}, involved: []string{"app-0:1", "glibc-minimal-langpack-0:1", "release-0:1"}},
+		{name: "should keep language packs of requested languages", lang: "de", requires: []string{"app"}, involved: []string{"app-0:1", "glibc-minimal-langpack-0:1", "glibc-langpack-de-0:1", "app-langpack-de-0:1", "release-0:1"}},
+		{name: "should match the language of locales", lang: "en_US.UTF-8", requires: []string{"app"}, involved: []string{"app-0:1", "glibc-minimal-langpack-0:1", "glibc-langpack-en-0:1", "release-0:1"}},
+		{name: "should keep language packs which are the only candidates", requires: []string{"tool"}, involved: []string{"tool-0:1", "glibc-langpack-en-0:1", "release-0:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			reducer := NewRepoReducer(&bazeldnf.Repositories{}, nil, "x86_64", "testdata/cache", Options{Lang: tt.lang, FedoraRelease: "release", WeakDeps: true})
+			reducer.packages = []api.Package{
+				newPkg("release", nil, nil, nil),
+				newPkg("app", nil, []string{"glibc-langpack"}, nil),
+				newPkg("tool", nil, []string{"glibc-langpack-en"}, nil),
+				newPkg("glibc-minimal-langpack", []string{"glibc-langpack"}, nil, nil),
+				newPkg("glibc-langpack-de", []string{"glibc-langpack"}, nil, nil),
+				newPkg("glibc-langpack-en", []string{"glibc-langpack"}, nil, nil),
+				newPkg("app-langpack-de", nil, nil, []string{"(app and langpacks-de)"}),
+			}
+			reducer.indexPackages()
+			_, involved, err := reducer.Resolve(tt.requires)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(involved)).To(ConsistOf(tt.involved))
+		})
+	}
+}
+
+func TestMultilib(t *testing.T) {
+	tests := []struct {
+		name     string
+		multilib bool
+		requires []string
+		matched  []string
+		involved []string
+		fail     bool
+	}{
+		{name: "should ignore multilib packages by default", requires: []string{"foo.i686"}, fail: true},
+		{name: "should prefer the primary architecture", multilib: true, requires: []string{"foo"}, matched: []string{"foo", "release"}},
+		{name: "should select multilib packages explicitly", multilib: true, requires: []string{"foo.i686"}, matched: []string{"foo.i686", "release"}},
+		{
+			name:     "should involve all architectures of the same version",
+			multilib: true,
+			requires: []string{"foo", "foo.i686"},
+			matched:  []string{"foo", "foo.i686", "release"},
+			involved: []string{"foo.x86_64", "foo.i686", "release.noarch"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			reducer := NewRepoReducer(&bazeldnf.Repositories{}, nil, "x86_64", "testdata/cache", Options{FedoraRelease: "release", Multilib: tt.multilib})
+			for _, p := range []api.Package{
+				{Name: "release", Arch: "noarch"},
+				{Name: "foo", Arch: "x86_64"},
+				{Name: "foo", Arch: "i686"},
+			} {
+				if !skip(p.Arch, reducer.architectures) {
+					reducer.packages = append(reducer.packages, p)
+				}
+			}
+			reducer.indexPackages()
+			matched, involved, err := reducer.Resolve(tt.requires)
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(matched).To(ConsistOf(tt.matched))
+			if tt.involved != nil {
+				names := []string{}
+				for _, p := range involved {
+					names = append(names, p.Name+"."+p.Arch)
+				}
+				g.Expect(names).To(ConsistOf(tt.involved))
+			}
+		})
+	}
+}
+
+func TestModules(t *testing.T) {
+	newPkg := func(name string, version string, release string) api.Package {
+		p := api.Package{Name: name, Arch: "x86_64"}
+		p.Version = api.Version{Epoch: "1", Ver: version, Rel: release}
+		return p
+	}
+	stream := func(name string, stream string, rpms ...string) api.ModuleStream {
+		s := api.ModuleStream{Name: api.ModuleString(name), Stream: api.ModuleString(stream)}
+		s.Artifacts.RPMs = rpms
+		return s
+	}
+	modules := &api.Modules{
+		Streams: []api.ModuleStream{
+			stream("nodejs", "10", "nodejs-1:10.0-1.module.x86_64", "npm-1:6.0-1.module.x86_64"),
+			stream("nodejs", "12", "nodejs-1:12.0-1.module.x86_64"),
+		},
+		Defaults: []api.ModuleDefaults{{Module: "nodejs", Stream: "10"}},
+	}
+	tests := []struct {
+		name     string
+		enable   []string
+		packages []string
+		fail     bool
+	}{
+		{name: "should only show packages of default streams", packages: []string{"bash-1:5.0-1", "nodejs-1:10.0-1.module", "npm-1:6.0-1.module"}},
+		{name: "should show packages of enabled streams", enable: []string{"nodejs:12"}, packages: []string{"bash-1:5.0-1", "nodejs-1:12.0-1.module"}},
+		{name: "should fail on unknown streams", enable: []string{"nodejs:14"}, fail: true},
+		{name: "should fail on unknown modules", enable: []string{"python:3"}, fail: true},
+		{name: "should fail on invalid modules", enable: []string{"nodejs"}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			packages := []api.Package{
+				newPkg("bash", "5.0", "1"),
+				newPkg("nodejs", "8.0", "1"),
+				newPkg("nodejs", "10.0", "1.module"),
+				newPkg("nodejs", "12.0", "1.module"),
+				newPkg("npm", "6.0", "1.module"),
+			}
+			filtered, err := filterModules(packages, modules, tt.enable)
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			names := []string{}
+			for _, p := range filtered {
+				names = append(names, p.String())
+			}
+			g.Expect(names).To(ConsistOf(tt.packages))
+		})
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	fedora := &bazeldnf.Repository{Name: "fedora"}
+	internal := &bazeldnf.Repository{Name: "internal", Priority: 10}
+	mirror := &bazeldnf.Repository{Name: "mirror", Cost: 500}
+	newPkg := func(name string, version string, arch string, repo *bazeldnf.Repository) api.Package {
+		p := api.Package{Name: name, Arch: arch, Repository: repo}
+		p.Version = api.Version{Ver: version, Rel: "1"}
+		return p
+	}
+	tests := []struct {
+		name     string
+		packages []api.Package
+		expected []string
+	}{
+		{
+			name: "should let higher priority repositories shadow newer packages",
+			packages: []api.Package{
+				newPkg("glibc", "2.33", "x86_64", fedora),
+				newPkg("glibc", "2.32", "x86_64", internal),
+				newPkg("bash", "5.1", "x86_64", fedora),
+			},
+			expected: []string{"glibc-0:2.32-1.x86_64 from internal", "bash-0:5.1-1.x86_64 from fedora"},
+		},
+		{
+			name: "should only shadow packages of the same architecture",
+			packages: []api.Package{
+				newPkg("glibc", "2.33", "i686", fedora),
+				newPkg("glibc", "2.32", "x86_64", internal),
+			},
+			expected: []string{"glibc-0:2.33-1.i686 from fedora", "glibc-0:2.32-1.x86_64 from internal"},
+		},
+		{
+			name: "should prefer cheaper repositories for the same package",
+			packages: []api.Package{
+				newPkg("bash", "5.1", "x86_64", fedora),
+				newPkg("bash", "5.1", "x86_64", mirror),
+				newPkg("bash", "5.0", "x86_64", fedora),
+			},
+			expected: []string{"bash-0:5.1-1.x86_64 from mirror", "bash-0:5.0-1.x86_64 from fedora"},
+		},
+		{
+			name: "should treat local packages like packages of default repositories",
+			packages: []api.Package{
+				newPkg("bash", "5.1", "x86_64", nil),
+				newPkg("bash", "5.0", "x86_64", fedora),
+			},
+			expected: []string{"bash-0:5.1-1.x86_64 from @local", "bash-0:5.0-1.x86_64 from fedora"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			origins := []string{}
+			for _, p := range filterPriorities(tt.packages) {
+				origins = append(origins, p.String()+"."+p.Arch+" from "+p.RepositoryName())
+			}
+			g.Expect(origins).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestRepositoryFilters(t *testing.T) {
+	newPkg := func(name string, arch string, repo *bazeldnf.Repository) api.Package {
+		p := api.Package{Name: name, Arch: arch, Repository: repo}
+		p.Version = api.Version{Ver: "1", Rel: "1"}
+		return p
+	}
+	tests := []struct {
+		name     string
+		repo     bazeldnf.Repository
+		expected []string
+	}{
+		{
+			name:     "should keep all packages without patterns",
+			expected: []string{"kernel-0:1-1", "kernel-devel-0:1-1", "glibc-0:1-1", "glibc-0:1-1", "bash-0:1-1"},
+		},
+		{
+			name:     "should hide excluded packages",
+			repo:     bazeldnf.Repository{ExcludePkgs: []string{"kernel*", "glibc.i686"}},
+			expected: []string{"glibc-0:1-1", "bash-0:1-1"},
+		},
+		{
+			name:     "should only keep included packages",
+			repo:     bazeldnf.Repository{IncludePkgs: []string{"glibc-1-1", "kernel"}},
+			expected: []string{"kernel-0:1-1", "glibc-0:1-1", "glibc-0:1-1", "bash-0:1-1"},
+		},
+		{
+			name:     "should apply excludes to included packages",
+			repo:     bazeldnf.Repository{IncludePkgs: []string{"kernel*"}, ExcludePkgs: []string{"*-devel"}},
+			expected: []string{"kernel-0:1-1", "bash-0:1-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			tt.repo.Name = "experimental"
+			packages := []api.Package{
+				newPkg("kernel", "x86_64", &tt.repo),
+				newPkg("kernel-devel", "x86_64", &tt.repo),
+				newPkg("glibc", "x86_64", &tt.repo),
+				newPkg("glibc", "i686", &tt.repo),
+				newPkg("bash", "x86_64", nil),
+			}
+			names := []string{}
+			for _, p := range filterRepositoryPackages(packages) {
+				names = append(names, p.String())
+			}
+			g.Expect(names).To(Equal(tt.expected))
+		})
+	}
+}
+
+func pkgToString(given []*api.Package) (resolved []string) {
+	for _, p := range given {
+		resolved = append(resolved, p.String())
+	}
+	return
+}
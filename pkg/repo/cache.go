@@ -1,14 +1,20 @@
 package repo
 
 import (
-	"compress/gzip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
@@ -27,6 +33,32 @@ func (r *CacheHelper) LoadMetaLink(repo *bazeldnf.Repository) (*api.Metalink, er
 	return metalink, nil
 }
 
+// LoadMirrorlist returns the mirrors listed in the cached mirrorlist of the repository. Empty lines and comments
+// are skipped.
+func (r *CacheHelper) LoadMirrorlist(repo *bazeldnf.Repository) ([]string, error) {
+	reader, err := r.OpenFromRepoDir(repo, "mirrorlist")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	mirrors := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "<") {
+			return nil, fmt.Errorf("mirrorlist of %s looks like a metalink, use the metalink field instead", repo.Name)
+		}
+		mirrors = append(mirrors, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mirrorlist of %s: %v", repo.Name, err)
+	}
+	return mirrors, nil
+}
+
 func (r *CacheHelper) WriteToRepoDir(repo *bazeldnf.Repository, body io.Reader, name string) error {
 	dir := filepath.Join(r.CacheDir, repo.Name)
 	file := filepath.Join(dir, name)
@@ -47,6 +79,153 @@ func (r *CacheHelper) WriteToRepoDir(repo *bazeldnf.Repository, body io.Reader,
 	return nil
 }
 
+// ManifestFile is the name of the file in each repository cache directory which records what was downloaded
+const ManifestFile = "manifest.json"
+
+// Manifest records the origin and the checksums of all files in the cache directory of a repository
+type Manifest struct {
+	Fetched time.Time                 `json:"fetched"`
+	Files   map[string]*ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+// LoadManifest returns the manifest of the cached repository. If nothing was cached yet, an empty manifest is returned.
+func (r *CacheHelper) LoadManifest(repo *bazeldnf.Repository) (*Manifest, error) {
+	manifest := &Manifest{Files: map[string]*ManifestEntry{}}
+	if _, err := os.Stat(filepath.Join(r.CacheDir, repo.Name, ManifestFile)); os.IsNotExist(err) {
+		return manifest, nil
+	}
+	reader, err := r.OpenFromRepoDir(repo, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of %s: %v", repo.Name, err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]*ManifestEntry{}
+	}
+	return manifest, nil
+}
+
+func (r *CacheHelper) WriteManifest(repo *bazeldnf.Repository, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest of %s: %v", repo.Name, err)
+	}
+	return r.WriteToRepoDir(repo, bytes.NewReader(data), ManifestFile)
+}
+
+// StagingHelper writes repository metadata into a temporary directory. The cached metadata of the repository is
+// only replaced with the staged content on Commit, so that failed downloads don't leave a broken cache behind.
+type StagingHelper struct {
+	*CacheHelper
+	target   *CacheHelper
+	repo     *bazeldnf.Repository
+	previous *Manifest
+	manifest *Manifest
+}
+
+func (r *CacheHelper) NewStagingHelper(repo *bazeldnf.Repository) (*StagingHelper, error) {
+	previous, err := r.LoadManifest(repo)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(r.CacheDir, 0770)
+	if err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to create cache directory %s: %v", r.CacheDir, err)
+	}
+	dir, err := ioutil.TempDir(r.CacheDir, "."+repo.Name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory for %s: %v", repo.Name, err)
+	}
+	return &StagingHelper{
+		CacheHelper: &CacheHelper{CacheDir: dir},
+		target:      r,
+		repo:        repo,
+		previous:    previous,
+		manifest:    &Manifest{Files: map[string]*ManifestEntry{}},
+	}, nil
+}
+
+// Previous returns the manifest entry of the last successful fetch for the given file, if the file is still cached
+func (s *StagingHelper) Previous(name string) *ManifestEntry {
+	entry := s.previous.Files[name]
+	if entry == nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(s.target.CacheDir, s.repo.Name, name)); err != nil {
+		return nil
+	}
+	return entry
+}
+
+// Record adds a freshly downloaded file to the manifest which gets written on Commit
+func (s *StagingHelper) Record(name string, entry *ManifestEntry) {
+	s.manifest.Files[name] = entry
+}
+
+// Reuse copies a file of the last successful fetch into the staging directory and returns its sha256 sum
+func (s *StagingHelper) Reuse(name string) (string, error) {
+	previous := s.Previous(name)
+	if previous == nil {
+		return "", fmt.Errorf("%s of %s is not cached", name, s.repo.Name)
+	}
+	reader, err := s.target.OpenFromRepoDir(s.repo, name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	sha := sha256.New()
+	if err := s.WriteToRepoDir(s.repo, io.TeeReader(reader, sha), name); err != nil {
+		return "", err
+	}
+	entry := *previous
+	entry.SHA256 = hex.EncodeToString(sha.Sum(nil))
+	s.Record(name, &entry)
+	return entry.SHA256, nil
+}
+
+// Commit writes the manifest and replaces the cached repository directory with the staged one. The old cache
+// directory is moved aside first and only removed once the staged one is in place, so that the last good cache
+// is restored if the replacement fails.
+func (s *StagingHelper) Commit() error {
+	s.manifest.Fetched = time.Now().UTC()
+	if err := s.WriteManifest(s.repo, s.manifest); err != nil {
+		return err
+	}
+	staged := filepath.Join(s.CacheDir, s.repo.Name)
+	dir := filepath.Join(s.target.CacheDir, s.repo.Name)
+	old := filepath.Join(s.CacheDir, ".previous-"+s.repo.Name)
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move old cache directory for %s aside: %v", s.repo.Name, err)
+	}
+	if err := os.Rename(staged, dir); err != nil {
+		if _, statErr := os.Stat(old); statErr == nil {
+			if restoreErr := os.Rename(old, dir); restoreErr != nil {
+				return fmt.Errorf("failed to move staged metadata for %s into the cache: %v, restoring the old cache directory failed: %v", s.repo.Name, err, restoreErr)
+			}
+		}
+		return fmt.Errorf("failed to move staged metadata for %s into the cache: %v", s.repo.Name, err)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("failed to remove old cache directory for %s: %v", s.repo.Name, err)
+	}
+	return nil
+}
+
+// Cleanup removes the staging directory
+func (s *StagingHelper) Cleanup() error {
+	return os.RemoveAll(s.CacheDir)
+}
+
 func (r *CacheHelper) OpenFromRepoDir(repo *bazeldnf.Repository, name string) (io.ReadCloser, error) {
 	dir := filepath.Join(r.CacheDir, repo.Name)
 	file := filepath.Join(dir, name)
@@ -57,6 +236,33 @@ func (r *CacheHelper) OpenFromRepoDir(repo *bazeldnf.Repository, name string) (i
 	return f, err
 }
 
+type compressedFile struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (c *compressedFile) Close() error {
+	err := c.ReadCloser.Close()
+	if fileErr := c.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// OpenCompressedFromRepoDir opens a compressed metadata file and transparently decompresses it
+func (r *CacheHelper) OpenCompressedFromRepoDir(repo *bazeldnf.Repository, name string) (io.ReadCloser, error) {
+	file, err := r.OpenFromRepoDir(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := Decompress(name, file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+	}
+	return &compressedFile{ReadCloser: reader, file: file}, nil
+}
+
 func (r *CacheHelper) UnmarshalFromRepoDir(repo *bazeldnf.Repository, name string, obj interface{}) error {
 	reader, err := r.OpenFromRepoDir(repo, name)
 	if err != nil {
@@ -73,13 +279,7 @@ func (r *CacheHelper) CurrentPrimary(repo *bazeldnf.Repository) (*api.Repository
 	}
 	primary := repomd.File(api.PrimaryFileType)
 	primaryName := filepath.Base(primary.Location.Href)
-	file, err := r.OpenFromRepoDir(repo, primaryName)
-	if err != nil {
-		return nil, err
-	}
-
-	defer file.Close()
-	reader, err := gzip.NewReader(file)
+	reader, err := r.OpenCompressedFromRepoDir(repo, primaryName)
 	if err != nil {
 		return nil, err
 	}
@@ -91,24 +291,14 @@ func (r *CacheHelper) CurrentPrimary(repo *bazeldnf.Repository) (*api.Repository
 		return nil, err
 	}
 
-	if len(repo.Mirrors) == 0 && repo.Metalink != "" {
-		metalink, err := r.LoadMetaLink(repo)
-		if err == nil {
-			urls := []string{}
-			for _, url := range metalink.Repomod().Resources.URLs {
-				if url.Type == "https" {
-					urls = append(urls, strings.TrimSuffix(url.Text, "repodata/repomd.xml"))
-				}
-				if len(urls) == 4 {
-					break
-				}
-			}
-			repo.Mirrors = urls
-		} else if !os.IsNotExist(err) {
+	if len(repo.Mirrors) == 0 {
+		mirrors, err := r.CurrentMirrors(repo)
+		if err != nil {
 			return nil, err
 		}
-	} else if len(repo.Mirrors) == 0 && repo.Baseurl != "" {
-		repo.Mirrors = []string{repo.Baseurl}
+		repo.Mirrors = mirrors
+	} else if len(repo.PreferredMirrors) > 0 {
+		repo.Mirrors = rankMirrors(&bazeldnf.Repository{PreferredMirrors: repo.PreferredMirrors, MirrorCount: len(repo.Mirrors)}, explicitMirrors(repo.Mirrors), nil)
 	}
 
 	for i, _ := range repository.Packages {
@@ -123,16 +313,13 @@ func (r *CacheHelper) CurrentFilelistsForPackages(repo *bazeldnf.Repository, arc
 		return nil, nil, err
 	}
 	filelists := repomd.File(api.FilelistsFileType)
-	filelistsName := filepath.Base(filelists.Location.Href)
-	file, err := r.OpenFromRepoDir(repo, filelistsName)
-	if err != nil {
-		return nil, nil, err
+	if filelists == nil {
+		return nil, nil, fmt.Errorf("no filelists referenced in repomd.xml of %s", repo.Name)
 	}
-
-	defer file.Close()
-	reader, err := gzip.NewReader(file)
+	filelistsName := filepath.Base(filelists.Location.Href)
+	reader, err := r.OpenCompressedFromRepoDir(repo, filelistsName)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("filelists of %s are not cached, fetch them first: %v", repo.Name, err)
 	}
 	defer reader.Close()
 
@@ -212,7 +399,15 @@ func (r *CacheHelper) CurrentFilelistsForPackages(repo *bazeldnf.Repository, arc
 
 func (r *CacheHelper) CurrentPrimaries(repos *bazeldnf.Repositories, arch string) (primaries []*api.Repository, err error) {
 	for i, repo := range repos.Repositories {
-		if repo.Arch != arch {
+		if repo.Arch != arch || repo.Disabled {
+			continue
+		}
+		if repo.Directory != "" {
+			primary, err := ScanDirectory(&repos.Repositories[i])
+			if err != nil {
+				return nil, err
+			}
+			primaries = append(primaries, primary)
 			continue
 		}
 		primary, err := r.CurrentPrimary(&repos.Repositories[i])
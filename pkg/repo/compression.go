@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xi2/xz"
+)
+
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionXZ    Compression = "xz"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+var compressionExtensions = map[string]Compression{
+	".xml":  CompressionNone,
+	".gz":   CompressionGzip,
+	".xz":   CompressionXZ,
+	".zst":  CompressionZstd,
+	".zstd": CompressionZstd,
+	".bz2":  CompressionBzip2,
+}
+
+var compressionMagic = []struct {
+	magic       []byte
+	compression Compression
+}{
+	{magic: []byte{0x1f, 0x8b}, compression: CompressionGzip},
+	{magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, compression: CompressionXZ},
+	{magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, compression: CompressionZstd},
+	{magic: []byte("BZh"), compression: CompressionBzip2},
+	{magic: []byte("<"), compression: CompressionNone},
+}
+
+// DetectCompression determines the compression of a repository metadata file based on the extension of its
+// name. If the extension is unknown, the first bytes of the content are inspected.
+func DetectCompression(name string, reader *bufio.Reader) (Compression, error) {
+	if compression, exists := compressionExtensions[filepath.Ext(name)]; exists {
+		return compression, nil
+	}
+	for _, m := range compressionMagic {
+		head, err := reader.Peek(len(m.magic))
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read header of %s: %v", name, err)
+		}
+		if bytes.Equal(head, m.magic) {
+			return m.compression, nil
+		}
+	}
+	return "", fmt.Errorf("could not detect the compression of %s", name)
+}
+
+// Decompress returns a reader which decompresses the given repository metadata file
+func Decompress(name string, reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	compression, err := DetectCompression(name, buffered)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case CompressionNone:
+		return ioutil.NopCloser(buffered), nil
+	case CompressionGzip:
+		return gzip.NewReader(buffered)
+	case CompressionXZ:
+		r, err := xz.NewReader(buffered, 0)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(r), nil
+	case CompressionZstd:
+		r, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return r.IOReadCloser(), nil
+	case CompressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(buffered)), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %s of %s", compression, name)
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestCompressedRepositories(t *testing.T) {
+	tests := []struct {
+		name string
+		repo string
+	}{
+		{name: "should read gzip compressed metadata", repo: "gzip"},
+		{name: "should read xz compressed metadata", repo: "xz"},
+		{name: "should read zstd compressed metadata", repo: "zstd"},
+		{name: "should read bzip2 compressed metadata", repo: "bzip2"},
+		{name: "should detect the compression of metadata without extension", repo: "magic"},
+		{name: "should read uncompressed metadata", repo: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			helper := &CacheHelper{CacheDir: "testdata/compression"}
+			repo := &bazeldnf.Repository{Name: tt.repo, Baseurl: "http://" + tt.repo}
+
+			primary, err := helper.CurrentPrimary(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(primary.Packages).To(HaveLen(1))
+			g.Expect(primary.Packages[0].Name).To(Equal("testpkg"))
+
+			filelists, remaining, err := helper.CurrentFilelistsForPackages(repo, []string{"noarch"}, []*api.Package{&primary.Packages[0]})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(remaining).To(BeEmpty())
+			g.Expect(filelists).To(HaveLen(1))
+			g.Expect(filelists[0].File).To(HaveLen(2))
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  []byte
+		expected Compression
+		fail     bool
+	}{
+		{name: "should prefer the extension", file: "primary.xml.xz", content: []byte{0x1f, 0x8b}, expected: CompressionXZ},
+		{name: "should detect gzip", file: "primary", content: []byte{0x1f, 0x8b, 0x08}, expected: CompressionGzip},
+		{name: "should detect xz", file: "primary", content: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, expected: CompressionXZ},
+		{name: "should detect zstd", file: "primary", content: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, expected: CompressionZstd},
+		{name: "should detect bzip2", file: "primary", content: []byte("BZh91AY"), expected: CompressionBzip2},
+		{name: "should detect plain xml", file: "primary", content: []byte("<?xml"), expected: CompressionNone},
+		{name: "should fail on unknown content", file: "primary", content: []byte("garbage"), fail: true},
+		{name: "should fail on empty content", file: "primary", content: []byte{}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			compression, err := DetectCompression(tt.file, bufio.NewReader(bytes.NewReader(tt.content)))
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(compression).To(Equal(tt.expected))
+		})
+	}
+}
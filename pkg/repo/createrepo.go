@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	repoNamespace   = "http://linux.duke.edu/metadata/repo"
+	commonNamespace = "http://linux.duke.edu/metadata/common"
+	rpmNamespace    = "http://linux.duke.edu/metadata/rpm"
+	fileNamespace   = "http://linux.duke.edu/metadata/filelists"
+)
+
+// CreateRepo reads the headers of all RPMs in the directory and its subdirectories and writes the repodata
+// directory with repomd.xml, primary.xml.gz and filelists.xml.gz, like createrepo does. Existing metadata is
+// replaced.
+func CreateRepo(dir string) (*api.Repomd, error) {
+	primary, err := ScanDirectory(&bazeldnf.Repository{Name: dir, Directory: dir})
+	if err != nil {
+		return nil, err
+	}
+	// the filelists of a repository are expected to be sorted by name
+	sort.SliceStable(primary.Packages, func(i, j int) bool {
+		pi, pj := primary.Packages[i], primary.Packages[j]
+		if pi.Name != pj.Name {
+			return pi.Name < pj.Name
+		}
+		if c := rpm.Compare(pi.Version, pj.Version); c != 0 {
+			return c < 0
+		}
+		return pi.Arch < pj.Arch
+	})
+
+	staging, err := ioutil.TempDir(dir, ".repodata")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	repomd := &api.Repomd{Xmlns: repoNamespace, Revision: strconv.FormatInt(now, 10)}
+	data, err := writeMetadata(staging, api.PrimaryFileType, now, func(w io.Writer) error {
+		return writePrimary(w, primary.Packages)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write primary metadata: %v", err)
+	}
+	repomd.Data = append(repomd.Data, *data)
+	data, err = writeMetadata(staging, api.FilelistsFileType, now, func(w io.Writer) error {
+		return writeFilelists(w, primary.Packages)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write filelists metadata: %v", err)
+	}
+	repomd.Data = append(repomd.Data, *data)
+
+	f, err := os.Create(filepath.Join(staging, "repomd.xml"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := writeXML(f, repomd); err != nil {
+		return nil, fmt.Errorf("failed to write repomd.xml: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	repodata := filepath.Join(dir, "repodata")
+	if err := os.RemoveAll(repodata); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staging, repodata); err != nil {
+		return nil, err
+	}
+	log.Infof("Wrote metadata of %d packages to %s", len(primary.Packages), repodata)
+	return repomd, nil
+}
+
+// writeMetadata writes a gzip compressed metadata file named after its checksum and returns its repomd.xml entry
+func writeMetadata(dir string, fileType string, timestamp int64, write func(w io.Writer) error) (*api.Data, error) {
+	tmp := filepath.Join(dir, fileType+".xml.gz")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	compressedSHA := sha256.New()
+	compressed := &countingWriter{writer: io.MultiWriter(f, compressedSHA)}
+	gz := gzip.NewWriter(compressed)
+	openSHA := sha256.New()
+	open := &countingWriter{writer: io.MultiWriter(gz, openSHA)}
+	if err := write(open); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	sha := toHex(compressedSHA)
+	name := fmt.Sprintf("%s-%s.xml.gz", sha, fileType)
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return nil, err
+	}
+	data := &api.Data{Type: fileType}
+	data.Checksum.Type = "sha256"
+	data.Checksum.Text = sha
+	data.OpenChecksum.Type = "sha256"
+	data.OpenChecksum.Text = toHex(openSHA)
+	data.Location.Href = "repodata/" + name
+	data.Timestamp = strconv.FormatInt(timestamp, 10)
+	data.Size = strconv.FormatInt(compressed.count, 10)
+	data.OpenSize = strconv.FormatInt(open.count, 10)
+	return data, nil
+}
+
+// primaryPackage is a package of the primary metadata like createrepo writes it, with the rpm specific format
+// elements in the rpm namespace. The header range is omitted since it is optional and only used to download
+// headers without the payload.
+type primaryPackage struct {
+	XMLName     xml.Name     `xml:"package"`
+	Type        string       `xml:"type,attr"`
+	Name        string       `xml:"name"`
+	Arch        string       `xml:"arch"`
+	Version     api.Version  `xml:"version"`
+	Checksum    api.Checksum `xml:"checksum"`
+	Summary     string       `xml:"summary"`
+	Description string       `xml:"description"`
+	Packager    string       `xml:"packager"`
+	URL         string       `xml:"url"`
+	Time        struct {
+		Text  string `xml:",chardata"`
+		File  string `xml:"file,attr"`
+		Build string `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Text      string `xml:",chardata"`
+		Package   string `xml:"package,attr"`
+		Installed string `xml:"installed,attr"`
+		Archive   string `xml:"archive,attr"`
+	} `xml:"size"`
+	Location api.Location `xml:"location"`
+	Format   struct {
+		License     string               `xml:"rpm:license"`
+		Vendor      string               `xml:"rpm:vendor"`
+		Group       string               `xml:"rpm:group"`
+		Buildhost   string               `xml:"rpm:buildhost"`
+		Sourcerpm   string               `xml:"rpm:sourcerpm"`
+		Provides    *primaryDependencies `xml:"rpm:provides"`
+		Requires    *primaryDependencies `xml:"rpm:requires"`
+		Conflicts   *primaryDependencies `xml:"rpm:conflicts"`
+		Obsoletes   *primaryDependencies `xml:"rpm:obsoletes"`
+		Recommends  *primaryDependencies `xml:"rpm:recommends"`
+		Suggests    *primaryDependencies `xml:"rpm:suggests"`
+		Enhances    *primaryDependencies `xml:"rpm:enhances"`
+		Supplements *primaryDependencies `xml:"rpm:supplements"`
+		Files       []api.ProvidedFile   `xml:"file"`
+	} `xml:"format"`
+}
+
+type primaryDependencies struct {
+	Entries []api.Entry `xml:"rpm:entry"`
+}
+
+// newPrimaryDependencies returns nil for empty dependencies, so that the element is omitted like createrepo does
+func newPrimaryDependencies(deps api.Dependencies) *primaryDependencies {
+	if len(deps.Entries) == 0 {
+		return nil
+	}
+	return &primaryDependencies{Entries: deps.Entries}
+}
+
+func newPrimaryPackage(pkg *api.Package) *primaryPackage {
+	primary := &primaryPackage{
+		Type:        pkg.Type,
+		Name:        pkg.Name,
+		Arch:        pkg.Arch,
+		Version:     pkg.Version,
+		Checksum:    pkg.Checksum,
+		Summary:     pkg.Summary,
+		Description: pkg.Description,
+		Packager:    pkg.Packager,
+		URL:         pkg.URL,
+		Time:        pkg.Time,
+		Size:        pkg.Size,
+		Location:    pkg.Location,
+	}
+	format := &primary.Format
+	format.License = pkg.Format.License
+	format.Vendor = pkg.Format.Vendor
+	format.Group = pkg.Format.Group
+	format.Buildhost = pkg.Format.Buildhost
+	format.Sourcerpm = pkg.Format.Sourcerpm
+	format.Provides = newPrimaryDependencies(pkg.Format.Provides)
+	format.Requires = newPrimaryDependencies(pkg.Format.Requires)
+	format.Conflicts = newPrimaryDependencies(pkg.Format.Conflicts)
+	format.Obsoletes = newPrimaryDependencies(pkg.Format.Obsoletes)
+	format.Recommends = newPrimaryDependencies(pkg.Format.Recommends)
+	format.Suggests = newPrimaryDependencies(pkg.Format.Suggests)
+	format.Enhances = newPrimaryDependencies(pkg.Format.Enhances)
+	format.Supplements = newPrimaryDependencies(pkg.Format.Supplements)
+	// like createrepo, only list the files in the primary metadata which are commonly required
+	for _, file := range pkg.Format.Files {
+		if isPrimaryFile(file.Text) {
+			format.Files = append(format.Files, file)
+		}
+	}
+	return primary
+}
+
+// writePrimary writes the primary metadata token by token, since encoding/xml can't declare namespace prefixes
+func writePrimary(w io.Writer, packages []api.Package) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	start := xml.StartElement{
+		Name: xml.Name{Local: "metadata"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: commonNamespace},
+			{Name: xml.Name{Local: "xmlns:rpm"}, Value: rpmNamespace},
+			{Name: xml.Name{Local: "packages"}, Value: strconv.Itoa(len(packages))},
+		},
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := range packages {
+		if err := encoder.Encode(newPrimaryPackage(&packages[i])); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+func writeFilelists(w io.Writer, packages []api.Package) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	start := xml.StartElement{
+		Name: xml.Name{Local: "filelists"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: fileNamespace},
+			{Name: xml.Name{Local: "packages"}, Value: strconv.Itoa(len(packages))},
+		},
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, pkg := range packages {
+		filelist := &api.FileListPackage{
+			Pkgid:   pkg.Checksum.Text,
+			Name:    pkg.Name,
+			Arch:    pkg.Arch,
+			Version: pkg.Version,
+			File:    pkg.Format.Files,
+		}
+		if err := encoder.EncodeElement(filelist, xml.StartElement{Name: xml.Name{Local: "package"}}); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+func writeXML(w io.Writer, obj interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(obj)
+}
+
+// isPrimaryFile returns true for the files which createrepo lists in the primary metadata
+func isPrimaryFile(file string) bool {
+	return strings.HasPrefix(file, "/etc/") || strings.Contains(file, "bin/") || file == "/usr/lib/sendmail"
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
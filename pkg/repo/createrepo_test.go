@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestCreateRepo(t *testing.T) {
+	g := NewGomegaWithT(t)
+	repoDir, err := ioutil.TempDir("", "bazeldnf-createrepo")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(repoDir)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	rpms, err := filepath.Glob("testdata/rpms/*.rpm")
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, rpm := range rpms {
+		data, err := ioutil.ReadFile(rpm)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ioutil.WriteFile(filepath.Join(repoDir, filepath.Base(rpm)), data, 0644)).To(Succeed())
+	}
+
+	repomd, err := CreateRepo(repoDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repomd.File(api.PrimaryFileType)).ToNot(BeNil())
+	g.Expect(repomd.File(api.FilelistsFileType)).ToNot(BeNil())
+
+	// running it again replaces the metadata
+	repomd, err = CreateRepo(repoDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	entries, err := ioutil.ReadDir(filepath.Join(repoDir, "repodata"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(HaveLen(3))
+
+	// the primary metadata uses the rpm namespace like createrepo
+	primaryFile, err := os.Open(filepath.Join(repoDir, repomd.File(api.PrimaryFileType).Location.Href))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer primaryFile.Close()
+	gz, err := gzip.NewReader(primaryFile)
+	g.Expect(err).ToNot(HaveOccurred())
+	raw, err := ioutil.ReadAll(gz)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(raw)).To(ContainSubstring(`<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="2">`))
+	g.Expect(string(raw)).To(ContainSubstring(`<rpm:provides>`))
+	g.Expect(string(raw)).To(ContainSubstring(`<rpm:entry name="simple" flags="EQ" epoch="0" ver="1.0.1" rel="1"></rpm:entry>`))
+	g.Expect(string(raw)).To(ContainSubstring(`<rpm:requires>`))
+	g.Expect(string(raw)).To(ContainSubstring(`<rpm:license>Public Domain</rpm:license>`))
+	g.Expect(string(raw)).ToNot(ContainSubstring(`<provides>`))
+	g.Expect(string(raw)).ToNot(ContainSubstring(`header-range`))
+
+	// the metadata has to pass the checksum verification of fetch
+	repos := []bazeldnf.Repository{{Name: "created", Baseurl: "file://" + filepath.ToSlash(repoDir) + "/", Arch: "x86_64"}}
+	fetcher := &RepoFetcherImpl{Getter: &getterImpl{}, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}, Filelists: true}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+
+	primary, err := fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages).To(HaveLen(2))
+	g.Expect(primary.Packages[0].String()).To(Equal("one-epoch-1:0.1-1"))
+	g.Expect(primary.Packages[1].String()).To(Equal("simple-0:1.0.1-1"))
+	g.Expect(primary.Packages[1].Location.Href).To(Equal("simple-1.0.1-1.i386.rpm"))
+	g.Expect(primary.Packages[1].Format.Requires.Entries).To(ConsistOf(api.Entry{Name: "config(simple)", Flags: "EQ", Epoch: "0", Ver: "1.0.1", Rel: "1"}))
+	// only files which are commonly required are listed in the primary metadata
+	g.Expect(primary.Packages[1].Format.Files).To(BeEmpty())
+
+	packages := []*api.Package{&primary.Packages[0], &primary.Packages[1]}
+	filelists, remaining, err := fetcher.CacheHelper.CurrentFilelistsForPackages(&repos[0], []string{"x86_64", "i386"}, packages)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remaining).To(BeEmpty())
+	g.Expect(filelists).To(HaveLen(2))
+	g.Expect(filelists[1].File).To(ConsistOf(
+		api.ProvidedFile{Text: "/config"},
+		api.ProvidedFile{Text: "/dir", Type: "dir"},
+		api.ProvidedFile{Text: "/normal"},
+	))
+}
@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+// distroPresets maps distribution names to functions returning the usual repositories of a release
+var distroPresets = map[string]func(release string, arch string) ([]bazeldnf.Repository, error){
+	"fedora":              fedoraRepositories,
+	"centos-stream":       centosStreamRepositories,
+	"rocky":               rockyRepositories,
+	"almalinux":           almaRepositories,
+	"opensuse-leap":       openSUSELeapRepositories,
+	"opensuse-tumbleweed": openSUSETumbleweedRepositories,
+}
+
+// Distros returns the names of all distributions with repository presets
+func Distros() []string {
+	distros := []string{}
+	for distro := range distroPresets {
+		distros = append(distros, distro)
+	}
+	sort.Strings(distros)
+	return distros
+}
+
+// DistroRepositories returns the usual repositories of the given distribution release
+func DistroRepositories(distro string, release string, arch string) ([]bazeldnf.Repository, error) {
+	preset, exists := distroPresets[distro]
+	if !exists {
+		return nil, fmt.Errorf("unknown distribution %s, supported are %s", distro, strings.Join(Distros(), ", "))
+	}
+	if release == "" && distro != "opensuse-tumbleweed" {
+		return nil, fmt.Errorf("a release of %s has to be specified", distro)
+	}
+	return preset(release, arch)
+}
+
+func fedoraRepositories(release string, arch string) ([]bazeldnf.Repository, error) {
+	release = strings.TrimPrefix(release, "f")
+	gpgKey := fmt.Sprintf("https://src.fedoraproject.org/rpms/fedora-repos/raw/rawhide/f/RPM-GPG-KEY-fedora-%s-primary", release)
+	return []bazeldnf.Repository{
+		{
+			Name:     fmt.Sprintf("%s-%s-primary-repo", release, arch),
+			Metalink: fmt.Sprintf("https://mirrors.fedoraproject.org/metalink?repo=fedora-%s&arch=%s", release, arch),
+			Arch:     arch,
+			GPGKey:   gpgKey,
+		},
+		{
+			Name:     fmt.Sprintf("%s-%s-update-repo", release, arch),
+			Metalink: fmt.Sprintf("https://mirrors.fedoraproject.org/metalink?repo=updates-released-f%s&arch=%s", release, arch),
+			Arch:     arch,
+			GPGKey:   gpgKey,
+		},
+	}, nil
+}
+
+func centosStreamRepositories(release string, arch string) ([]bazeldnf.Repository, error) {
+	release = strings.TrimSuffix(release, "-stream")
+	gpgKey := "https://www.centos.org/keys/RPM-GPG-KEY-CentOS-Official"
+	switch release {
+	case "8":
+		// CentOS Stream 8 reached its end of life, its content is only archived on the vault
+		repos := []bazeldnf.Repository{}
+		for _, name := range []string{"BaseOS", "AppStream", "PowerTools"} {
+			repos = append(repos, bazeldnf.Repository{
+				Name:    fmt.Sprintf("centos-stream-8-%s-%s", strings.ToLower(name), arch),
+				Baseurl: fmt.Sprintf("https://vault.centos.org/8-stream/%s/%s/os/", name, arch),
+				Arch:    arch,
+				GPGKey:  gpgKey,
+			})
+		}
+		return repos, nil
+	default:
+		repos := []bazeldnf.Repository{}
+		for _, name := range []string{"baseos", "appstream", "crb"} {
+			repos = append(repos, bazeldnf.Repository{
+				Name:     fmt.Sprintf("centos-stream-%s-%s-%s", release, name, arch),
+				Metalink: fmt.Sprintf("https://mirrors.centos.org/metalink?repo=centos-%s-%s-stream&arch=%s", name, release, arch),
+				Arch:     arch,
+				GPGKey:   gpgKey,
+			})
+		}
+		return repos, nil
+	}
+}
+
+func rockyRepositories(release string, arch string) ([]bazeldnf.Repository, error) {
+	gpgKey := fmt.Sprintf("https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-Rocky-%s", majorVersion(release))
+	if majorVersion(release) == "8" {
+		gpgKey = "https://dl.rockylinux.org/pub/rocky/RPM-GPG-KEY-rockyofficial"
+	}
+	return enterpriseLinuxRepositories("rocky", "https://dl.rockylinux.org/pub/rocky", release, arch, gpgKey), nil
+}
+
+func almaRepositories(release string, arch string) ([]bazeldnf.Repository, error) {
+	gpgKey := fmt.Sprintf("https://repo.almalinux.org/almalinux/RPM-GPG-KEY-AlmaLinux-%s", majorVersion(release))
+	if majorVersion(release) == "8" {
+		gpgKey = "https://repo.almalinux.org/almalinux/RPM-GPG-KEY-AlmaLinux"
+	}
+	return enterpriseLinuxRepositories("almalinux", "https://repo.almalinux.org/almalinux", release, arch, gpgKey), nil
+}
+
+// enterpriseLinuxRepositories returns the BaseOS, AppStream and CRB repositories of RHEL rebuilds.
+// Release 8 still calls CRB PowerTools.
+func enterpriseLinuxRepositories(distro string, baseurl string, release string, arch string, gpgKey string) []bazeldnf.Repository {
+	names := []string{"BaseOS", "AppStream", "CRB"}
+	if majorVersion(release) == "8" {
+		names[2] = "PowerTools"
+	}
+	repos := []bazeldnf.Repository{}
+	for _, name := range names {
+		repos = append(repos, bazeldnf.Repository{
+			Name:    fmt.Sprintf("%s-%s-%s-%s", distro, release, strings.ToLower(name), arch),
+			Baseurl: fmt.Sprintf("%s/%s/%s/%s/os/", baseurl, release, name, arch),
+			Arch:    arch,
+			GPGKey:  gpgKey,
+		})
+	}
+	return repos
+}
+
+func openSUSELeapRepositories(release string, arch string) ([]bazeldnf.Repository, error) {
+	return []bazeldnf.Repository{
+		{
+			Name:    fmt.Sprintf("opensuse-leap-%s-oss-%s", release, arch),
+			Baseurl: fmt.Sprintf("https://download.opensuse.org/distribution/leap/%s/repo/oss/", release),
+			Arch:    arch,
+			GPGKey:  fmt.Sprintf("https://download.opensuse.org/distribution/leap/%s/repo/oss/repodata/repomd.xml.key", release),
+		},
+		{
+			Name:    fmt.Sprintf("opensuse-leap-%s-update-%s", release, arch),
+			Baseurl: fmt.Sprintf("https://download.opensuse.org/update/leap/%s/oss/", release),
+			Arch:    arch,
+			GPGKey:  fmt.Sprintf("https://download.opensuse.org/update/leap/%s/oss/repodata/repomd.xml.key", release),
+		},
+	}, nil
+}
+
+func openSUSETumbleweedRepositories(_ string, arch string) ([]bazeldnf.Repository, error) {
+	return []bazeldnf.Repository{
+		{
+			Name:    fmt.Sprintf("opensuse-tumbleweed-oss-%s", arch),
+			Baseurl: "https://download.opensuse.org/tumbleweed/repo/oss/",
+			Arch:    arch,
+			GPGKey:  "https://download.opensuse.org/tumbleweed/repo/oss/repodata/repomd.xml.key",
+		},
+		{
+			Name:    fmt.Sprintf("opensuse-tumbleweed-update-%s", arch),
+			Baseurl: "https://download.opensuse.org/update/tumbleweed/",
+			Arch:    arch,
+			GPGKey:  "https://download.opensuse.org/update/tumbleweed/repodata/repomd.xml.key",
+		},
+	}, nil
+}
+
+func majorVersion(release string) string {
+	return strings.SplitN(release, ".", 2)[0]
+}
@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestDistroRepositories(t *testing.T) {
+	tests := []struct {
+		name     string
+		distro   string
+		release  string
+		arch     string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:    "should use the fedora metalinks",
+			distro:  "fedora",
+			release: "f34",
+			arch:    "x86_64",
+			expected: []string{
+				"https://mirrors.fedoraproject.org/metalink?repo=fedora-34&arch=x86_64",
+				"https://mirrors.fedoraproject.org/metalink?repo=updates-released-f34&arch=x86_64",
+			},
+		},
+		{
+			name:    "should use the centos stream metalinks",
+			distro:  "centos-stream",
+			release: "9",
+			arch:    "aarch64",
+			expected: []string{
+				"https://mirrors.centos.org/metalink?repo=centos-baseos-9-stream&arch=aarch64",
+				"https://mirrors.centos.org/metalink?repo=centos-appstream-9-stream&arch=aarch64",
+				"https://mirrors.centos.org/metalink?repo=centos-crb-9-stream&arch=aarch64",
+			},
+		},
+		{
+			name:    "should use the vault for the end of life centos stream 8",
+			distro:  "centos-stream",
+			release: "8",
+			arch:    "x86_64",
+			expected: []string{
+				"https://vault.centos.org/8-stream/BaseOS/x86_64/os/",
+				"https://vault.centos.org/8-stream/AppStream/x86_64/os/",
+				"https://vault.centos.org/8-stream/PowerTools/x86_64/os/",
+			},
+		},
+		{
+			name:    "should use PowerTools instead of CRB for release 8",
+			distro:  "rocky",
+			release: "8.5",
+			arch:    "x86_64",
+			expected: []string{
+				"https://dl.rockylinux.org/pub/rocky/8.5/BaseOS/x86_64/os/",
+				"https://dl.rockylinux.org/pub/rocky/8.5/AppStream/x86_64/os/",
+				"https://dl.rockylinux.org/pub/rocky/8.5/PowerTools/x86_64/os/",
+			},
+		},
+		{
+			name:    "should use the almalinux repositories",
+			distro:  "almalinux",
+			release: "9",
+			arch:    "x86_64",
+			expected: []string{
+				"https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/",
+				"https://repo.almalinux.org/almalinux/9/AppStream/x86_64/os/",
+				"https://repo.almalinux.org/almalinux/9/CRB/x86_64/os/",
+			},
+		},
+		{
+			name:    "should use the opensuse leap repositories",
+			distro:  "opensuse-leap",
+			release: "15.4",
+			arch:    "x86_64",
+			expected: []string{
+				"https://download.opensuse.org/distribution/leap/15.4/repo/oss/",
+				"https://download.opensuse.org/update/leap/15.4/oss/",
+			},
+		},
+		{
+			name:   "should not need a release for opensuse tumbleweed",
+			distro: "opensuse-tumbleweed",
+			arch:   "x86_64",
+			expected: []string{
+				"https://download.opensuse.org/tumbleweed/repo/oss/",
+				"https://download.opensuse.org/update/tumbleweed/",
+			},
+		},
+		{
+			name:    "should fail on unknown distributions",
+			distro:  "debian",
+			release: "11",
+			wantErr: true,
+		},
+		{
+			name:    "should fail without a release",
+			distro:  "centos-stream",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			repos, err := DistroRepositories(tt.distro, tt.release, tt.arch)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			urls := []string{}
+			for _, repo := range repos {
+				g.Expect(repo.Arch).To(Equal(tt.arch))
+				g.Expect(repo.GPGKey).ToNot(BeEmpty())
+				urls = append(urls, repo.Baseurl+repo.Metalink)
+			}
+			g.Expect(urls).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestAppendRepositories(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tmpdir, err := ioutil.TempDir("", "bazeldnf-init")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(tmpdir)
+	file := filepath.Join(tmpdir, "repo.yaml")
+
+	g.Expect(NewRemoteInit("34", "x86_64", file).Init()).To(Succeed())
+	g.Expect(NewDistroInit("centos-stream", "9", "x86_64", file).Init()).To(MatchError(ContainSubstring("already exists")))
+
+	init := NewDistroInit("centos-stream", "9", "x86_64", file)
+	init.Append = true
+	g.Expect(init.Init()).To(Succeed())
+	g.Expect(init.Init()).To(MatchError("repository centos-stream-9-baseos-x86_64 already exists"))
+
+	repos, err := LoadRepoFile(file)
+	g.Expect(err).ToNot(HaveOccurred())
+	names := []string{}
+	for _, repo := range repos.Repositories {
+		names = append(names, repo.Name)
+	}
+	g.Expect(names).To(Equal([]string{
+		"34-x86_64-primary-repo",
+		"34-x86_64-update-repo",
+		"centos-stream-9-baseos-x86_64",
+		"centos-stream-9-appstream-x86_64",
+		"centos-stream-9-crb-x86_64",
+	}))
+	g.Expect(repos.Repositories[0]).To(Equal(bazeldnf.Repository{
+		Name:     "34-x86_64-primary-repo",
+		Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-34&arch=x86_64",
+		Arch:     "x86_64",
+		GPGKey:   "https://src.fedoraproject.org/rpms/fedora-repos/raw/rawhide/f/RPM-GPG-KEY-fedora-34-primary",
+	}))
+}
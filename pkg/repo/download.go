@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDownloadWorkers is the number of RPMs which are downloaded concurrently if not specified otherwise
+const DefaultDownloadWorkers = 8
+
+// RPMDownload is a RPM which can be downloaded from any of its URLs and has to match the sha256 sum
+type RPMDownload struct {
+	Name   string
+	SHA256 string
+	URLs   []string
+}
+
+// RPMDownloader stores RPMs in a content-addressed directory where each RPM is named after its sha256 sum
+type RPMDownloader struct {
+	Getter  Getter
+	Dir     string
+	Workers int
+}
+
+func NewRPMDownloader(getter Getter, dir string, workers int) *RPMDownloader {
+	return &RPMDownloader{
+		Getter:  getter,
+		Dir:     dir,
+		Workers: workers,
+	}
+}
+
+// Download downloads all RPMs which are not already present in the directory. The URLs of each RPM are tried in
+// order until one of them delivers the expected content.
+func (d *RPMDownloader) Download(rpms []RPMDownload) error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	workers := d.Workers
+	if workers <= 0 {
+		workers = DefaultDownloadWorkers
+	}
+
+	errs := make([]error, len(rpms))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers && w < len(rpms); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = d.download(&rpms[i])
+			}
+		}()
+	}
+	for i := range rpms {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	msgs := []string{}
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", rpms[i].Name, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("failed to download %d RPMs: %s", len(msgs), strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Path returns the location of a RPM in the directory
+func (d *RPMDownloader) Path(sha256sum string) string {
+	return filepath.Join(d.Dir, sha256sum)
+}
+
+func (d *RPMDownloader) download(rpm *RPMDownload) error {
+	if rpm.SHA256 == "" {
+		return fmt.Errorf("no sha256 sum specified")
+	}
+	if sum, err := fileSHA256(d.Path(rpm.SHA256)); err == nil && sum == rpm.SHA256 {
+		log.Debugf("%s is already present", rpm.Name)
+		return nil
+	}
+	if len(rpm.URLs) == 0 {
+		return fmt.Errorf("no urls specified")
+	}
+	var lastErr error
+	for _, url := range rpm.URLs {
+		if lastErr = d.downloadFrom(rpm, url); lastErr == nil {
+			log.Infof("Downloaded %s from %s", rpm.Name, url)
+			return nil
+		}
+		log.Warningf("Failed to download %s from %s: %v", rpm.Name, url, lastErr)
+	}
+	return lastErr
+}
+
+// downloadFrom stores the RPM under its sha256 sum once the whole file was downloaded and verified
+func (d *RPMDownloader) downloadFrom(rpm *RPMDownload, url string) error {
+	resp, err := d.Getter.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status : %v", resp.StatusCode)
+	}
+	tmp, err := ioutil.TempFile(d.Dir, ".download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, sha), resp.Body); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if toHex(sha) != rpm.SHA256 {
+		return fmt.Errorf("expected sha256 sum %s, but got %s", rpm.SHA256, toHex(sha))
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.Path(rpm.SHA256))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(sha, f); err != nil {
+		return "", err
+	}
+	return toHex(sha), nil
+}
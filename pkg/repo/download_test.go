@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func sha256Of(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownload(t *testing.T) {
+	tests := []struct {
+		name    string
+		rpms    []RPMDownload
+		setup   func(g *fakeGetter)
+		failing []string
+	}{
+		{
+			name: "should download all RPMs",
+			rpms: []RPMDownload{
+				{Name: "a", SHA256: sha256Of("a"), URLs: []string{"http://mirror/a.rpm"}},
+				{Name: "b", SHA256: sha256Of("b"), URLs: []string{"http://mirror/b.rpm"}},
+				{Name: "c", SHA256: sha256Of("c"), URLs: []string{"http://mirror/c.rpm"}},
+			},
+			setup: func(g *fakeGetter) {
+				g.responses["http://mirror/a.rpm"] = &fakeResponse{body: []byte("a"), delay: 20 * time.Millisecond}
+				g.responses["http://mirror/b.rpm"] = &fakeResponse{body: []byte("b"), delay: 20 * time.Millisecond}
+				g.responses["http://mirror/c.rpm"] = &fakeResponse{body: []byte("c")}
+			},
+		},
+		{
+			name: "should fall back to the next URL",
+			rpms: []RPMDownload{
+				{Name: "a", SHA256: sha256Of("a"), URLs: []string{"http://broken/a.rpm", "http://corrupted/a.rpm", "http://mirror/a.rpm"}},
+			},
+			setup: func(g *fakeGetter) {
+				g.responses["http://broken/a.rpm"] = &fakeResponse{err: fmt.Errorf("connection reset")}
+				g.responses["http://corrupted/a.rpm"] = &fakeResponse{body: []byte("corrupted")}
+				g.responses["http://mirror/a.rpm"] = &fakeResponse{body: []byte("a")}
+			},
+		},
+		{
+			name: "should report all RPMs which don't match their checksum",
+			rpms: []RPMDownload{
+				{Name: "a", SHA256: sha256Of("a"), URLs: []string{"http://mirror/a.rpm"}},
+				{Name: "b", SHA256: sha256Of("b"), URLs: []string{"http://mirror/b.rpm"}},
+				{Name: "c", SHA256: sha256Of("c"), URLs: []string{"http://mirror/missing.rpm"}},
+			},
+			setup: func(g *fakeGetter) {
+				g.responses["http://mirror/a.rpm"] = &fakeResponse{body: []byte("a")}
+				g.responses["http://mirror/b.rpm"] = &fakeResponse{body: []byte("corrupted")}
+			},
+			failing: []string{"b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			dir, err := ioutil.TempDir("", "bazeldnf-mirror")
+			g.Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+			tt.setup(getter)
+			downloader := &RPMDownloader{Getter: getter, Dir: dir, Workers: 2}
+			err = downloader.Download(tt.rpms)
+			failed := map[string]bool{}
+			for _, name := range tt.failing {
+				failed[name] = true
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(name + ":"))
+			}
+			if len(tt.failing) == 0 {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(getter.maxFlight).To(BeNumerically("<=", 2))
+
+			for _, rpm := range tt.rpms {
+				data, err := ioutil.ReadFile(downloader.Path(rpm.SHA256))
+				if failed[rpm.Name] {
+					g.Expect(os.IsNotExist(err)).To(BeTrue())
+					continue
+				}
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(sha256Of(string(data))).To(Equal(rpm.SHA256))
+			}
+			// no temporary files should be left behind
+			entries, err := ioutil.ReadDir(dir)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(entries).To(HaveLen(len(tt.rpms) - len(tt.failing)))
+		})
+	}
+}
+
+func TestDownloadSkipsPresentRPMs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	dir, err := ioutil.TempDir("", "bazeldnf-mirror")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	getter.responses["http://mirror/a.rpm"] = &fakeResponse{body: []byte("a")}
+	downloader := &RPMDownloader{Getter: getter, Dir: dir}
+	rpms := []RPMDownload{{Name: "a", SHA256: sha256Of("a"), URLs: []string{"http://mirror/a.rpm"}}}
+	g.Expect(downloader.Download(rpms)).To(Succeed())
+	g.Expect(downloader.Download(rpms)).To(Succeed())
+	g.Expect(getter.transferred["http://mirror/a.rpm"]).To(Equal(1))
+
+	// broken files are replaced
+	g.Expect(ioutil.WriteFile(downloader.Path(sha256Of("a")), []byte("corrupted"), 0644)).To(Succeed())
+	g.Expect(downloader.Download(rpms)).To(Succeed())
+	g.Expect(getter.transferred["http://mirror/a.rpm"]).To(Equal(2))
+}
@@ -1,16 +1,20 @@
 package repo
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
@@ -21,69 +25,169 @@ type RepoFetcher interface {
 	Fetch() error
 }
 
+// DefaultFetchWorkers is the number of repositories which are fetched concurrently if not specified otherwise
+const DefaultFetchWorkers = 4
+
 type RepoFetcherImpl struct {
 	Getter      Getter
 	Repos       []bazeldnf.Repository
 	CacheHelper *CacheHelper
+	Workers     int
+	// Filelists enables fetching the filelists metadata, which is needed to resolve requirements on files which are
+	// not listed in the primary metadata
+	Filelists bool
+	// ProbeMirrors measures the latency of all mirrors of a metalink or a mirrorlist, which is then used to rank
+	// the mirrors of the generated rpm rules
+	ProbeMirrors bool
+	ProbeTimeout time.Duration
 }
 
-func (r *RepoFetcherImpl) Fetch() (err error) {
-	for _, repo := range r.Repos {
-		sha256sum := []string{}
-		var repomdURLs = []string{}
-		if repo.Metalink != "" {
-			var metalink *api.Metalink
-			metalink, repomdURLs, err = r.resolveMetaLink(&repo)
-			if err != nil {
-				return fmt.Errorf("failed to resolve metalink for %s: %v", repo.Name, err)
-			}
-			sha256sum, err = metalink.Repomod().SHA256()
-			if err != nil {
-				return fmt.Errorf("failed to get sha256sum of repomd file: %v", err)
+// FetchError contains all errors which occurred while fetching the metadata of multiple repositories
+type FetchError struct {
+	Repos  []string
+	Errors []error
+}
+
+func (e *FetchError) Error() string {
+	msgs := []string{}
+	for i, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", e.Repos[i], err))
+	}
+	return fmt.Sprintf("failed to fetch %d repositories: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (r *RepoFetcherImpl) Fetch() error {
+	enabled := []int{}
+	for i, repo := range r.Repos {
+		if repo.Disabled {
+			log.Infof("Skipping disabled repository %s", repo.Name)
+			continue
+		}
+		if repo.Directory != "" {
+			log.Infof("Skipping local repository %s, its directory is read directly", repo.Name)
+			continue
+		}
+		enabled = append(enabled, i)
+	}
+	workers := r.Workers
+	if workers <= 0 {
+		workers = DefaultFetchWorkers
+	}
+	if workers > len(enabled) {
+		workers = len(enabled)
+	}
+
+	errs := make([]error, len(r.Repos))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = r.fetchRepo(&r.Repos[i])
 			}
-		} else if repo.Baseurl != "" {
-			repomdURLs = append(repomdURLs, strings.TrimSuffix(repo.Baseurl, "/")+"/repodata/repomd.xml")
+		}()
+	}
+	for _, i := range enabled {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	fetchErr := &FetchError{}
+	for i, err := range errs {
+		if err != nil {
+			fetchErr.Repos = append(fetchErr.Repos, r.Repos[i].Name)
+			fetchErr.Errors = append(fetchErr.Errors, err)
+		}
+	}
+	if len(fetchErr.Errors) > 0 {
+		return fetchErr
+	}
+	return nil
+}
+
+// fetchRepo downloads all metadata of a repository into a staging directory and only replaces the cached
+// metadata once everything was downloaded and verified successfully.
+func (r *RepoFetcherImpl) fetchRepo(repo *bazeldnf.Repository) (err error) {
+	staging, err := r.CacheHelper.NewStagingHelper(repo)
+	if err != nil {
+		return err
+	}
+	defer staging.Cleanup()
+
+	sha256sum := []string{}
+	var repomdURLs = []string{}
+	if repo.Metalink != "" {
+		var metalink *api.Metalink
+		metalink, repomdURLs, err = r.resolveMetaLink(staging, repo)
+		if err != nil {
+			return fmt.Errorf("failed to resolve metalink for %s: %v", repo.Name, err)
+		}
+		sha256sum, err = metalink.Repomod().SHA256()
+		if err != nil {
+			return fmt.Errorf("failed to get sha256sum of repomd file: %v", err)
 		}
-		repomd, mirror, err := r.resolveRepomd(&repo, repomdURLs, sha256sum)
+	} else if repo.Mirrorlist != "" {
+		// mirrorlists don't pin the repomd.xml checksum, take the first mirror which serves a valid one
+		repomdURLs, err = r.resolveMirrorlist(staging, repo)
 		if err != nil {
-			return fmt.Errorf("failed to fetch repomd.xml for %s: %v", repo.Name, err)
+			return fmt.Errorf("failed to resolve mirrorlist for %s: %v", repo.Name, err)
+		}
+	}
+	if repo.Metalink == "" && repo.Baseurl != "" {
+		repomdURLs = append(repomdURLs, strings.TrimSuffix(repo.Baseurl, "/")+"/repodata/repomd.xml")
+	}
+	repomd, mirror, err := r.resolveRepomd(staging, repo, repomdURLs, sha256sum)
+	if err != nil {
+		return fmt.Errorf("failed to fetch repomd.xml for %s: %v", repo.Name, err)
+	}
+	if r.ProbeMirrors && len(repomdURLs) > 1 {
+		if err := r.probeMirrors(staging, repo, repomdURLs); err != nil {
+			return fmt.Errorf("failed to probe mirrors of %s: %v", repo.Name, err)
 		}
-		err = r.fetchFile(api.PrimaryFileType, &repo, repomd, mirror)
+	}
+	err = r.fetchFile(staging, api.PrimaryFileType, repo, repomd, mirror)
+	if err != nil {
+		return fmt.Errorf("failed to fetch primary.xml for %s: %v", repo.Name, err)
+	}
+	if repomd.File(api.ModulesFileType) != nil {
+		err = r.fetchFile(staging, api.ModulesFileType, repo, repomd, mirror)
 		if err != nil {
-			return fmt.Errorf("failed to fetch primary.xml for %s: %v", repo.Name, err)
+			return fmt.Errorf("failed to fetch modules.yaml for %s: %v", repo.Name, err)
 		}
-		/* not used right now, save some bandwidth
-		err = r.fetchFile(api.FilelistsFileType, &repo, repomd, mirror)
+	}
+	if r.Filelists {
+		err = r.fetchFile(staging, api.FilelistsFileType, repo, repomd, mirror)
 		if err != nil {
 			return fmt.Errorf("failed to fetch filelists.xml for %s: %v", repo.Name, err)
 		}
-		*/
 	}
-	return nil
+	return staging.Commit()
 }
 
-func NewRemoteRepoFetcher(repos []bazeldnf.Repository, cacheDir string) RepoFetcher {
-	return &RepoFetcherImpl{
-		Repos:       repos,
-		Getter:      &getterImpl{},
-		CacheHelper: &CacheHelper{CacheDir: cacheDir},
+func NewRemoteRepoFetcher(repos []bazeldnf.Repository, cacheDir string, workers int, filelists bool, probeMirrors bool) (RepoFetcher, error) {
+	getter, err := NewGetter(repos)
+	if err != nil {
+		return nil, err
 	}
+	return &RepoFetcherImpl{
+		Repos:        repos,
+		Getter:       getter,
+		CacheHelper:  &CacheHelper{CacheDir: cacheDir},
+		Workers:      workers,
+		Filelists:    filelists,
+		ProbeMirrors: probeMirrors,
+	}, nil
 }
 
-func (r *RepoFetcherImpl) resolveMetaLink(repo *bazeldnf.Repository) (*api.Metalink, []string, error) {
-	resp, err := r.Getter.Get(repo.Metalink)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, nil, fmt.Errorf("Failed to download %s: %v ", repo.Metalink, fmt.Errorf("status : %v", resp.StatusCode))
-	}
-	if err := r.CacheHelper.WriteToRepoDir(repo, resp.Body, "metalink"); err != nil {
+func (r *RepoFetcherImpl) resolveMetaLink(staging *StagingHelper, repo *bazeldnf.Repository) (*api.Metalink, []string, error) {
+	if _, err := r.download(staging, "metalink", repo.Metalink); err != nil {
 		return nil, nil, err
 	}
 
-	metalink, err := r.CacheHelper.LoadMetaLink(repo)
+	metalink, err := staging.LoadMetaLink(repo)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -109,33 +213,39 @@ func (r *RepoFetcherImpl) resolveMetaLink(repo *bazeldnf.Repository) (*api.Metal
 	return metalink, urls, nil
 }
 
-func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []string, sha256sums []string) (repomd *api.Repomd, mirror *url.URL, err error) {
+func (r *RepoFetcherImpl) resolveMirrorlist(staging *StagingHelper, repo *bazeldnf.Repository) ([]string, error) {
+	if _, err := r.download(staging, "mirrorlist", repo.Mirrorlist); err != nil {
+		return nil, err
+	}
+	mirrors, err := staging.LoadMirrorlist(repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(mirrors) == 0 {
+		return nil, fmt.Errorf("Mirrorlist contains no mirrors")
+	}
+	urls := []string{}
+	for _, mirror := range mirrors {
+		urls = append(urls, strings.TrimSuffix(mirror, "/")+"/repodata/repomd.xml")
+	}
+	return urls, nil
+}
+
+func (r *RepoFetcherImpl) resolveRepomd(staging *StagingHelper, repo *bazeldnf.Repository, repomdURLs []string, sha256sums []string) (repomd *api.Repomd, mirror *url.URL, err error) {
 	for _, u := range repomdURLs {
-		sha := sha256.New()
 		log.Infof("Resolving repomd.xml from %s", u)
-		resp, err := r.Getter.Get(u)
+		sha, err := r.download(staging, "repomd.xml", u)
 		if err != nil {
 			log.Errorf("Failed to resolve repomd.xml from %s: %v", u, err)
 			continue
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			log.Warningf("Failed to download %s: %v ", u, fmt.Errorf("status : %v", resp.StatusCode))
-			continue
-		}
-		body := io.TeeReader(resp.Body, sha)
-		err = r.CacheHelper.WriteToRepoDir(repo, body, "repomd.xml")
-		if err != nil {
-			log.Errorf("Failed to save repomd.xml from %s: %v", u, err)
-			continue
-		}
 		if len(sha256sums) > 0 {
 			matched := false
 			for _, sum := range sha256sums {
-				if toHex(sha) != sum {
-					log.Warnf("Expected repomd.xml sha256 sum %s, but got %s", sum, toHex(sha))
+				if sha != sum {
+					log.Warnf("Expected repomd.xml sha256 sum %s, but got %s", sum, sha)
 				} else {
-					log.Infof("Matched repmod.xml with sha256 sum %s", toHex(sha))
+					log.Infof("Matched repmod.xml with sha256 sum %s", sha)
 					matched = true
 					break
 				}
@@ -147,7 +257,7 @@ func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []
 		}
 
 		file := &api.Repomd{}
-		err = r.CacheHelper.UnmarshalFromRepoDir(repo, "repomd.xml", file)
+		err = staging.UnmarshalFromRepoDir(repo, "repomd.xml", file)
 		if err != nil {
 			log.Errorf("Failed to decode repomd.xml from %s: %v", u, err)
 			continue
@@ -167,7 +277,7 @@ func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []
 	return repomd, mirror, nil
 }
 
-func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository, repomd *api.Repomd, mirror *url.URL) (err error) {
+func (r *RepoFetcherImpl) fetchFile(staging *StagingHelper, fileType string, repo *bazeldnf.Repository, repomd *api.Repomd, mirror *url.URL) (err error) {
 	file := repomd.File(fileType)
 	if file == nil {
 		return fmt.Errorf("No 'file' file referenced in repomd")
@@ -175,6 +285,10 @@ func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository,
 	if file.Location.Href == "" {
 		return fmt.Errorf("The 'file' file has no href associated")
 	}
+	sha256sum, err := file.SHA256()
+	if err != nil {
+		return fmt.Errorf("failed to get sha256sum of file: %v", err)
+	}
 
 	fileURL := file.Location.Href
 	fileName := filepath.Base(file.Location.Href)
@@ -183,39 +297,125 @@ func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository,
 		mirrorCopy.Path = path.Join(mirror.Path, file.Location.Href)
 		fileURL = mirrorCopy.String()
 	}
+
+	if previous := staging.Previous(fileName); previous != nil && previous.SHA256 == sha256sum {
+		sha, err := staging.Reuse(fileName)
+		if err == nil && sha == sha256sum {
+			log.Infof("The %s file %s is unchanged, skipping download", fileType, fileName)
+			return nil
+		}
+		log.Warningf("Cached %s file %s is corrupted, downloading it again", fileType, fileName)
+	}
+
 	log.Infof("Loading %s file from %s", fileType, fileURL)
-	resp, err := r.Getter.Get(fileURL)
+	sha, err := r.download(staging, fileName, fileURL)
 	if err != nil {
-		return fmt.Errorf("Failed to load promary repository file from %s: %v", fileURL, err)
+		return fmt.Errorf("Failed to load %s repository file from %s: %v", fileType, fileURL, err)
+	}
+	if sha256sum != sha {
+		return fmt.Errorf("Expected sha256 sum %s, but got %s", sha256sum, sha)
+	}
+	return nil
+}
+
+// download stores the content behind fileURL as name in the staging directory and returns its sha256 sum. If the
+// last fetch recorded validators for the same URL, a conditional request is issued and the cached file is reused
+// if it did not change. Interrupted transfers are resumed where they stopped.
+func (r *RepoFetcherImpl) download(staging *StagingHelper, name string, fileURL string) (string, error) {
+	var resp *http.Response
+	var err error
+	if previous := staging.Previous(name); previous != nil && previous.URL == fileURL && (previous.ETag != "" || previous.LastModified != "") {
+		resp, err = r.Getter.GetConditional(fileURL, previous.ETag, previous.LastModified)
+	} else {
+		resp, err = r.Getter.Get(fileURL)
+	}
+	if err != nil {
+		return "", err
 	}
-	sha := sha256.New()
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		log.Infof("%s from %s is unchanged, reusing the cached version", name, fileURL)
+		return staging.Reuse(name)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("Failed to download %s: %v ", fileURL, fmt.Errorf("status : %v", resp.StatusCode))
+		return "", fmt.Errorf("Failed to download %s: %v ", fileURL, fmt.Errorf("status : %v", resp.StatusCode))
 	}
-	body := io.TeeReader(resp.Body, sha)
-	err = r.CacheHelper.WriteToRepoDir(repo, body, fileName)
-	if err != nil {
-		return fmt.Errorf("Failed to write file.xml from %s to file: %v", fileURL, err)
+	body := &resumingReader{
+		getter:    r.Getter,
+		url:       fileURL,
+		validator: rangeValidator(resp),
+		body:      resp.Body,
+		retries:   DefaultRetries,
 	}
-	sha256sum, err := file.SHA256()
+	defer body.Close()
+	sha := sha256.New()
+	if err := staging.WriteToRepoDir(staging.repo, io.TeeReader(body, sha), name); err != nil {
+		return "", err
+	}
+	staging.Record(name, &ManifestEntry{
+		URL:          fileURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       toHex(sha),
+	})
+	return toHex(sha), nil
+}
+
+// resumingReader continues interrupted transfers with range requests which start where the previous attempt
+// stopped. It fails if the content changed in the meantime.
+type resumingReader struct {
+	getter    Getter
+	url       string
+	validator string
+	body      io.ReadCloser
+	offset    int64
+	retries   int
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF || r.retries <= 0 {
+		return n, err
+	}
+	r.retries--
+	log.Warnf("Download of %s was interrupted after %d bytes, resuming: %v", r.url, r.offset, err)
+	if err := r.resume(); err != nil {
+		return n, fmt.Errorf("failed to resume the download of %s: %v", r.url, err)
+	}
+	return n, nil
+}
+
+func (r *resumingReader) resume() error {
+	r.body.Close()
+	r.body = ioutil.NopCloser(&bytes.Buffer{})
+	resp, err := r.getter.GetRange(r.url, r.offset, r.validator)
 	if err != nil {
-		return fmt.Errorf("failed to get sha256sum of file: %v", err)
+		return err
 	}
-	if sha256sum != toHex(sha) {
-		return fmt.Errorf("Expected sha256 sum %s, but got %s", sha256sum, toHex(sha))
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("the content changed or the server does not support range requests, status : %v", resp.StatusCode)
 	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.offset)) {
+		resp.Body.Close()
+		return fmt.Errorf("unexpected content range %q", resp.Header.Get("Content-Range"))
+	}
+	r.body = resp.Body
 	return nil
 }
 
-type Getter interface {
-	Get(url string) (resp *http.Response, err error)
+func (r *resumingReader) Close() error {
+	return r.body.Close()
 }
 
-type getterImpl struct{}
-
-func (*getterImpl) Get(url string) (resp *http.Response, err error) {
-	return http.Get(url)
+// rangeValidator returns the validator which makes sure that a resumed transfer continues with the same content,
+// weak ETags can't be used for range requests
+func rangeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
 }
 
 func toHex(hasher hash.Hash) string {
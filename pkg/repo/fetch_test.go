@@ -0,0 +1,399 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+type fakeResponse struct {
+	body   []byte
+	etag   string
+	status int
+	err    error
+	delay  time.Duration
+}
+
+type fakeGetter struct {
+	lock      sync.Mutex
+	responses map[string]*fakeResponse
+	inFlight  int
+	maxFlight int
+	// transferred counts how often the body of a URL was sent
+	transferred map[string]int
+}
+
+func (f *fakeGetter) GetConditional(url string, etag string, lastModified string) (*http.Response, error) {
+	f.lock.Lock()
+	resp := f.responses[url]
+	f.lock.Unlock()
+	if resp != nil && resp.err == nil && resp.etag != "" && resp.etag == etag {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+	return f.Get(url)
+}
+
+func (f *fakeGetter) Get(url string) (*http.Response, error) {
+	return f.GetWithContext(context.Background(), url)
+}
+
+func (f *fakeGetter) GetWithContext(ctx context.Context, url string) (*http.Response, error) {
+	f.lock.Lock()
+	if f.transferred == nil {
+		f.transferred = map[string]int{}
+	}
+	f.inFlight++
+	if f.inFlight > f.maxFlight {
+		f.maxFlight = f.inFlight
+	}
+	resp := f.responses[url]
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		f.inFlight--
+		f.lock.Unlock()
+	}()
+
+	if resp == nil {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+	select {
+	case <-time.After(resp.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	status := resp.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := http.Header{}
+	if resp.etag != "" {
+		header.Set("ETag", resp.etag)
+	}
+	f.lock.Lock()
+	f.transferred[url]++
+	f.lock.Unlock()
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(bytes.NewReader(resp.body))}, nil
+}
+
+func (f *fakeGetter) GetRange(url string, offset int64, validator string) (*http.Response, error) {
+	resp, err := f.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+		return nil, err
+	}
+	resp.StatusCode = http.StatusPartialContent
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-", offset))
+	return resp, nil
+}
+
+// addRepo registers a minimal rpm-md repository with a single package under the given baseurl and returns the
+// URL of the primary file
+func (f *fakeGetter) addRepo(baseurl string, pkgName string, delay time.Duration) string {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	fmt.Fprintf(w, `<metadata packages="1"><package type="rpm"><name>%s</name><arch>noarch</arch></package></metadata>`, pkgName)
+	w.Close()
+	primary := buf.Bytes()
+	sum := sha256.Sum256(primary)
+	sha := hex.EncodeToString(sum[:])
+	repomd := fmt.Sprintf(`<repomd><data type="primary"><checksum type="sha256">%s</checksum><location href="repodata/%s-primary.xml.gz"/></data></repomd>`, sha, sha)
+
+	f.responses[baseurl+"/repodata/repomd.xml"] = &fakeResponse{body: []byte(repomd), delay: delay}
+	primaryURL := baseurl + "/repodata/" + sha + "-primary.xml.gz"
+	f.responses[primaryURL] = &fakeResponse{body: primary, delay: delay}
+	return primaryURL
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		repos   []bazeldnf.Repository
+		setup   func(g *fakeGetter)
+		workers int
+		failing []string
+	}{
+		{
+			name: "should fetch all repositories",
+			repos: []bazeldnf.Repository{
+				{Name: "a", Baseurl: "http://a"},
+				{Name: "b", Baseurl: "http://b"},
+				{Name: "c", Baseurl: "http://c"},
+			},
+			setup: func(g *fakeGetter) {
+				g.addRepo("http://a", "pkga", 50*time.Millisecond)
+				g.addRepo("http://b", "pkgb", 0)
+				g.addRepo("http://c", "pkgc", 10*time.Millisecond)
+			},
+			workers: 3,
+		},
+		{
+			name: "should report all failing repositories",
+			repos: []bazeldnf.Repository{
+				{Name: "a", Baseurl: "http://a"},
+				{Name: "b", Baseurl: "http://b"},
+				{Name: "c", Baseurl: "http://c"},
+				{Name: "d", Baseurl: "http://d"},
+			},
+			setup: func(g *fakeGetter) {
+				g.addRepo("http://a", "pkga", 0)
+				g.addRepo("http://b", "pkgb", 10*time.Millisecond)
+				g.responses["http://b/repodata/repomd.xml"].err = fmt.Errorf("connection reset")
+				g.addRepo("http://c", "pkgc", 0)
+				g.responses[g.addRepo("http://d", "pkgd", 0)].status = http.StatusInternalServerError
+			},
+			workers: 2,
+			failing: []string{"b", "d"},
+		},
+		{
+			name: "should work with a single worker",
+			repos: []bazeldnf.Repository{
+				{Name: "a", Baseurl: "http://a"},
+				{Name: "b", Baseurl: "http://b"},
+			},
+			setup: func(g *fakeGetter) {
+				g.addRepo("http://a", "pkga", 0)
+				g.addRepo("http://b", "pkgb", 0)
+			},
+			workers: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+			g.Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(cacheDir)
+
+			getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+			tt.setup(getter)
+			fetcher := &RepoFetcherImpl{
+				Getter:      getter,
+				Repos:       tt.repos,
+				CacheHelper: &CacheHelper{CacheDir: cacheDir},
+				Workers:     tt.workers,
+			}
+			err = fetcher.Fetch()
+			if len(tt.failing) > 0 {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.(*FetchError).Repos).To(ConsistOf(tt.failing))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(getter.maxFlight).To(BeNumerically("<=", tt.workers))
+
+			failed := map[string]bool{}
+			for _, name := range tt.failing {
+				failed[name] = true
+			}
+			for i, repo := range tt.repos {
+				if failed[repo.Name] {
+					_, err := os.Stat(filepath.Join(cacheDir, repo.Name))
+					g.Expect(os.IsNotExist(err)).To(BeTrue())
+					continue
+				}
+				primary, err := fetcher.CacheHelper.CurrentPrimary(&tt.repos[i])
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(primary.Packages).To(HaveLen(1))
+				g.Expect(primary.Packages[0].Name).To(Equal("pkg" + repo.Name))
+			}
+
+			// no staging directories should be left behind
+			entries, err := ioutil.ReadDir(cacheDir)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(entries).To(HaveLen(len(tt.repos) - len(tt.failing)))
+		})
+	}
+}
+
+func TestFetchKeepsCacheOnFailure(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	repos := []bazeldnf.Repository{{Name: "a", Baseurl: "http://a"}}
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	getter.addRepo("http://a", "pkga", 0)
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+
+	// the mirror now serves a repomd.xml which references a broken primary file
+	getter.responses = map[string]*fakeResponse{}
+	getter.responses[getter.addRepo("http://a", "pkgnew", 0)].body = []byte("corrupted")
+	g.Expect(fetcher.Fetch()).ToNot(Succeed())
+
+	primary, err := fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages[0].Name).To(Equal("pkga"))
+}
+
+func TestFetchReusesUnchangedMetadata(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	repos := []bazeldnf.Repository{{Name: "a", Baseurl: "http://a"}}
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	primaryURL := getter.addRepo("http://a", "pkga", 0)
+	getter.responses["http://a/repodata/repomd.xml"].etag = `"v1"`
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+
+	manifest, err := fetcher.CacheHelper.LoadManifest(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifest.Fetched.IsZero()).To(BeFalse())
+	g.Expect(manifest.Files["repomd.xml"].ETag).To(Equal(`"v1"`))
+	g.Expect(manifest.Files[filepath.Base(primaryURL)].SHA256).ToNot(BeEmpty())
+
+	// fetching again with an unchanged repomd.xml
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	g.Expect(getter.transferred["http://a/repodata/repomd.xml"]).To(Equal(1))
+	g.Expect(getter.transferred[primaryURL]).To(Equal(1))
+
+	// fetching again with a repomd.xml without validators which still references the same primary file
+	getter.responses["http://a/repodata/repomd.xml"].etag = ""
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	g.Expect(getter.transferred["http://a/repodata/repomd.xml"]).To(Equal(2))
+	g.Expect(getter.transferred[primaryURL]).To(Equal(1))
+
+	// fetching again after the repository changed
+	newPrimaryURL := getter.addRepo("http://a", "pkgnew", 0)
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	g.Expect(getter.transferred[newPrimaryURL]).To(Equal(1))
+	primary, err := fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages[0].Name).To(Equal("pkgnew"))
+}
+
+func TestFetchMirrorlist(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	repos := []bazeldnf.Repository{{Name: "a", Mirrorlist: "http://mirrors/list"}}
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	getter.responses["http://mirrors/list"] = &fakeResponse{body: []byte("# mirrors of a\nhttp://down/a/\n\nhttp://up/a\n")}
+	getter.addRepo("http://up/a", "pkga", 0)
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	g.Expect(getter.transferred["http://up/a/repodata/repomd.xml"]).To(Equal(1))
+
+	primary, err := fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages[0].Name).To(Equal("pkga"))
+	g.Expect(repos[0].Mirrors).To(Equal([]string{"http://down/a/", "http://up/a/"}))
+
+	// mirrorlists without any working mirror fail
+	getter.responses["http://mirrors/list"].body = []byte("http://down/a\n")
+	g.Expect(fetcher.Fetch()).ToNot(Succeed())
+}
+
+func TestFetchSkipsDisabledRepositories(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	repos := &bazeldnf.Repositories{Repositories: []bazeldnf.Repository{
+		{Name: "a", Baseurl: "http://a", Arch: "x86_64"},
+		{Name: "b", Baseurl: "http://b", Arch: "x86_64", Disabled: true},
+	}}
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	getter.addRepo("http://a", "pkga", 0)
+	getter.addRepo("http://b", "pkgb", 0)
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos.Repositories, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	g.Expect(getter.transferred["http://b/repodata/repomd.xml"]).To(BeZero())
+
+	primaries, err := fetcher.CacheHelper.CurrentPrimaries(repos, "x86_64")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primaries).To(HaveLen(1))
+	g.Expect(primaries[0].Packages[0].Name).To(Equal("pkga"))
+}
+
+func TestDownloadResumesInterruptedTransfers(t *testing.T) {
+	content := bytes.Repeat([]byte("bazeldnf"), 1024)
+	tests := []struct {
+		name     string
+		etag     string
+		changed  bool
+		succeed  bool
+		requests int
+	}{
+		{name: "should resume where the transfer stopped", etag: `"v1"`, succeed: true, requests: 2},
+		{name: "should fail if the content changed", etag: `"v1"`, changed: true, requests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+			g.Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(cacheDir)
+
+			lock := sync.Mutex{}
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				requests++
+				first := requests == 1
+				lock.Unlock()
+				etag := tt.etag
+				if !first && tt.changed {
+					etag = `"v2"`
+				}
+				w.Header().Set("ETag", etag)
+				if first {
+					// announce the whole content but stop in the middle
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content[:len(content)/2])
+					return
+				}
+				http.ServeContent(w, r, "primary.xml.gz", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+
+			repo := &bazeldnf.Repository{Name: "a"}
+			fetcher := &RepoFetcherImpl{Getter: &getterImpl{}, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+			staging, err := fetcher.CacheHelper.NewStagingHelper(repo)
+			g.Expect(err).ToNot(HaveOccurred())
+			defer staging.Cleanup()
+
+			sha, err := fetcher.download(staging, "primary.xml.gz", server.URL+"/repodata/primary.xml.gz")
+			g.Expect(requests).To(Equal(tt.requests))
+			if !tt.succeed {
+				g.Expect(err).To(MatchError(ContainSubstring("content changed")))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			sum := sha256.Sum256(content)
+			g.Expect(sha).To(Equal(hex.EncodeToString(sum[:])))
+			data, err := ioutil.ReadFile(filepath.Join(staging.CacheDir, "a", "primary.xml.gz"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(data).To(Equal(content))
+		})
+	}
+}
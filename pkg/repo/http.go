@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRetries is the number of times a failed request is repeated before giving up
+const DefaultRetries = 3
+
+// DefaultRetryBackoff is the time to wait before the first retry, it doubles with every further retry
+const DefaultRetryBackoff = time.Second
+
+// timeouts of the http client, there is no overall timeout since RPMs and metadata can be big
+const (
+	dialTimeout           = 30 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 60 * time.Second
+)
+
+type Getter interface {
+	Get(url string) (resp *http.Response, err error)
+	// GetConditional only transfers the content if it changed according to the given ETag and Last-Modified
+	// validators. Unchanged content is signaled with a http.StatusNotModified response.
+	GetConditional(url string, etag string, lastModified string) (resp *http.Response, err error)
+	// GetWithContext aborts the request, including retries, once the context is done
+	GetWithContext(ctx context.Context, url string) (resp *http.Response, err error)
+	// GetRange requests the content from the given offset on. If the validator, an ETag or a Last-Modified date,
+	// does not match anymore, the server sends the whole content with http.StatusOK instead of
+	// http.StatusPartialContent.
+	GetRange(url string, offset int64, validator string) (resp *http.Response, err error)
+}
+
+// getterImpl downloads files from http(s) and file:// URLs. Requests which fail because of network or server
+// errors are retried with an exponential backoff.
+type getterImpl struct {
+	client  *http.Client
+	repos   []*repoClient
+	retries int
+	backoff time.Duration
+}
+
+// repoClient authenticates all requests to URLs below the prefixes of a repository. Prefixes which don't end with
+// a / only match the exact URL, like a metalink or a mirrorlist.
+type repoClient struct {
+	name     string
+	prefixes []string
+	client   *http.Client
+	username string
+	password string
+}
+
+// NewGetter returns the getter which is shared by fetch, verify and mirror. Requests honor HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY. Requests to URLs below the baseurl, the mirrors or the preferred mirrors of a repository and to
+// its metalink or mirrorlist use its CA certificates, its client certificate and its credentials. The mirrors
+// listed by a metalink or a mirrorlist are deliberately excluded, secrets are never sent to third-party hosts.
+func NewGetter(repos []bazeldnf.Repository) (Getter, error) {
+	getter := &getterImpl{
+		client:  newHTTPClient(nil),
+		retries: DefaultRetries,
+		backoff: DefaultRetryBackoff,
+	}
+	for i := range repos {
+		repo := &repos[i]
+		if repo.SSLCACert == "" && repo.SSLClientCert == "" && repo.SSLClientKey == "" && repo.Username == "" && repo.Password == "" {
+			continue
+		}
+		client := getter.client
+		tlsConfig, err := repoTLSConfig(repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure TLS for %s: %v", repo.Name, err)
+		}
+		if tlsConfig != nil {
+			client = newHTTPClient(tlsConfig)
+		}
+		username, err := expandEnv(repo.Username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the username of %s: %v", repo.Name, err)
+		}
+		password, err := expandEnv(repo.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the password of %s: %v", repo.Name, err)
+		}
+		getter.repos = append(getter.repos, &repoClient{
+			name:     repo.Name,
+			prefixes: repoURLs(repo),
+			client:   client,
+			username: username,
+			password: password,
+		})
+	}
+	return getter, nil
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   tlsHandshakeTimeout,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       tlsConfig,
+		},
+	}
+}
+
+// repoTLSConfig returns nil if the repository neither has CA certificates nor a client certificate
+func repoTLSConfig(repo *bazeldnf.Repository) (*tls.Config, error) {
+	if repo.SSLCACert == "" && repo.SSLClientCert == "" && repo.SSLClientKey == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if repo.SSLCACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := ioutil.ReadFile(repo.SSLCACert)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", repo.SSLCACert)
+		}
+		config.RootCAs = pool
+	}
+	if repo.SSLClientCert != "" || repo.SSLClientKey != "" {
+		if repo.SSLClientCert == "" {
+			return nil, fmt.Errorf("sslclientkey requires a sslclientcert")
+		}
+		// like curl, expect the key next to the certificate if no separate key is given
+		key := repo.SSLClientKey
+		if key == "" {
+			key = repo.SSLClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(repo.SSLClientCert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// envReference matches values which consist of a single ${VAR} or $VAR reference
+var envReference = regexp.MustCompile(`^\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))$`)
+
+// isEnvReference returns true if the whole value is a reference to an environment variable
+func isEnvReference(value string) bool {
+	return envReference.MatchString(value)
+}
+
+// expandEnv reads values which consist of a single ${VAR} or $VAR reference from the environment and fails on
+// unset variables, instead of silently sending an empty secret. All other values are used literally, so that
+// secrets may contain a $.
+func expandEnv(value string) (string, error) {
+	match := envReference.FindStringSubmatch(value)
+	if match == nil {
+		return value, nil
+	}
+	name := match[1] + match[2]
+	env, exists := os.LookupEnv(name)
+	if !exists {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return env, nil
+}
+
+// repoURLs returns the URL prefixes of a repository. Directories end with a /, so that a baseurl like
+// https://host/repo does not match https://host/repo2.
+func repoURLs(repo *bazeldnf.Repository) []string {
+	urls := []string{}
+	for _, u := range []string{repo.Metalink, repo.Mirrorlist} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	dirs := append([]string{repo.Baseurl}, repo.Mirrors...)
+	for _, u := range append(dirs, repo.PreferredMirrors...) {
+		if u != "" {
+			urls = append(urls, strings.TrimSuffix(u, "/")+"/")
+		}
+	}
+	return urls
+}
+
+// repoClient returns the configuration of the repository with the longest URL prefix matching the URL
+func (g *getterImpl) repoClient(u string) *repoClient {
+	var match *repoClient
+	longest := 0
+	for _, repo := range g.repos {
+		for _, prefix := range repo.prefixes {
+			matches := u == prefix || strings.HasSuffix(prefix, "/") && strings.HasPrefix(u, prefix)
+			if matches && len(prefix) > longest {
+				match = repo
+				longest = len(prefix)
+			}
+		}
+	}
+	return match
+}
+
+func (g *getterImpl) Get(url string) (resp *http.Response, err error) {
+	return g.GetWithContext(context.Background(), url)
+}
+
+func (g *getterImpl) GetWithContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	if strings.HasPrefix(url, "file://") {
+		return getFile(url)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return g.do(req)
+}
+
+func (g *getterImpl) GetConditional(url string, etag string, lastModified string) (resp *http.Response, err error) {
+	if strings.HasPrefix(url, "file://") {
+		return getFile(url)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return g.do(req)
+}
+
+func (g *getterImpl) GetRange(url string, offset int64, validator string) (resp *http.Response, err error) {
+	if strings.HasPrefix(url, "file://") {
+		return getFile(url)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	return g.do(req)
+}
+
+func (g *getterImpl) do(req *http.Request) (*http.Response, error) {
+	client := g.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if repo := g.repoClient(req.URL.String()); repo != nil {
+		log.Debugf("Using the configuration of %s for %s", repo.name, req.URL)
+		client = repo.client
+		if repo.username != "" || repo.password != "" {
+			req.SetBasicAuth(repo.username, repo.password)
+		}
+	}
+
+	backoff := g.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= g.retries || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err != nil {
+			log.Warnf("Request to %s failed, retrying in %v: %v", req.URL, backoff, err)
+		} else {
+			resp.Body.Close()
+			log.Warnf("Request to %s failed with status %v, retrying in %v", req.URL, resp.StatusCode, backoff)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true for network errors and for server errors which are usually temporary
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// getFile serves file:// URLs like a http server would, missing files result in http.StatusNotFound
+func getFile(fileURL string) (*http.Response, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.FromSlash(u.Path))
+	if os.IsNotExist(err) {
+		return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: f}, nil
+}
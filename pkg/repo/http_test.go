@@ -0,0 +1,207 @@
+package repo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestGetterRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		retries  int
+		expected int
+		requests int
+	}{
+		{name: "should retry server errors", failures: 2, status: http.StatusServiceUnavailable, retries: 3, expected: http.StatusOK, requests: 3},
+		{name: "should retry rate limited requests", failures: 1, status: http.StatusTooManyRequests, retries: 3, expected: http.StatusOK, requests: 2},
+		{name: "should give up after the last retry", failures: 5, status: http.StatusBadGateway, retries: 2, expected: http.StatusBadGateway, requests: 3},
+		{name: "should not retry client errors", failures: 5, status: http.StatusNotFound, retries: 3, expected: http.StatusNotFound, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			lock := sync.Mutex{}
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				requests++
+				if requests <= tt.failures {
+					w.WriteHeader(tt.status)
+				}
+			}))
+			defer server.Close()
+
+			getter := &getterImpl{client: newHTTPClient(nil), retries: tt.retries, backoff: time.Millisecond}
+			resp, err := getter.Get(server.URL + "/repodata/repomd.xml")
+			g.Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			g.Expect(resp.StatusCode).To(Equal(tt.expected))
+			g.Expect(requests).To(Equal(tt.requests))
+		})
+	}
+}
+
+func TestGetterBasicAuth(t *testing.T) {
+	g := NewGomegaWithT(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "bazeldnf" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	repos := []bazeldnf.Repository{{Name: "internal", Baseurl: server.URL + "/internal", Mirrorlist: server.URL + "/mirrors?repo=internal", Username: "bazeldnf", Password: "${BAZELDNF_TEST_PASSWORD}"}}
+	_, err := NewGetter(repos)
+	g.Expect(err).To(MatchError(ContainSubstring("environment variable BAZELDNF_TEST_PASSWORD is not set")))
+
+	os.Setenv("BAZELDNF_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("BAZELDNF_TEST_PASSWORD")
+	getter, err := NewGetter(repos)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	resp, err := getter.Get(server.URL + "/internal/Packages/a.rpm")
+	g.Expect(err).ToNot(HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	resp, err = getter.Get(server.URL + "/mirrors?repo=internal")
+	g.Expect(err).ToNot(HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	// credentials are only sent to the URLs of the repository
+	for _, u := range []string{"/public/Packages/a.rpm", "/internal2/Packages/a.rpm", "/mirrors?repo=public"} {
+		resp, err = getter.Get(server.URL + u)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized), u)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("BAZELDNF_TEST_SECRET", "secret")
+	defer os.Unsetenv("BAZELDNF_TEST_SECRET")
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      string
+	}{
+		{name: "should read a braced reference", value: "${BAZELDNF_TEST_SECRET}", expected: "secret"},
+		{name: "should read a plain reference", value: "$BAZELDNF_TEST_SECRET", expected: "secret"},
+		{name: "should fail on unset variables", value: "${BAZELDNF_TEST_UNSET}", err: "environment variable BAZELDNF_TEST_UNSET is not set"},
+		{name: "should keep literal values", value: "bazeldnf", expected: "bazeldnf"},
+		{name: "should keep a literal $ in a password", value: "pa$$word", expected: "pa$$word"},
+		{name: "should keep a literal $ at the start of a password", value: "$ecret!", expected: "$ecret!"},
+		{name: "should not expand references inside a password", value: "pre${BAZELDNF_TEST_SECRET}", expected: "pre${BAZELDNF_TEST_SECRET}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			value, err := expandEnv(tt.value)
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(tt.err))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(value).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestGetterCertificates(t *testing.T) {
+	g := NewGomegaWithT(t)
+	dir, err := ioutil.TempDir("", "bazeldnf-certs")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey, client := writeCertificate(g, dir, "client")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	pool := x509.NewCertPool()
+	pool.AddCert(client)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+	serverCA := filepath.Join(dir, "server-ca.pem")
+	g.Expect(ioutil.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)).To(Succeed())
+
+	tests := []struct {
+		name    string
+		repo    bazeldnf.Repository
+		succeed bool
+	}{
+		{name: "should fail without the CA of the server", repo: bazeldnf.Repository{SSLClientCert: clientCert, SSLClientKey: clientKey}},
+		{name: "should fail without a client certificate", repo: bazeldnf.Repository{SSLCACert: serverCA}},
+		{name: "should succeed with the CA and the client certificate", repo: bazeldnf.Repository{SSLCACert: serverCA, SSLClientCert: clientCert, SSLClientKey: clientKey}, succeed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			tt.repo.Name = "satellite"
+			tt.repo.Baseurl = server.URL + "/"
+			getter, err := NewGetter([]bazeldnf.Repository{tt.repo})
+			g.Expect(err).ToNot(HaveOccurred())
+			getter.(*getterImpl).retries = 0
+
+			resp, err := getter.Get(server.URL + "/repodata/repomd.xml")
+			if !tt.succeed {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	}
+
+	_, err = NewGetter([]bazeldnf.Repository{{Name: "broken", SSLCACert: clientKey}})
+	g.Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+}
+
+// writeCertificate writes a self-signed client certificate and its key as PEM files
+func writeCertificate(g *WithT, dir string, name string) (certFile string, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err = x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	g.Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)).To(Succeed())
+	g.Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)).To(Succeed())
+	return certFile, keyFile, cert
+}
@@ -4,59 +4,104 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	"sigs.k8s.io/yaml"
 )
 
 type RepoInit struct {
-	OS                 string
-	Arch               string
-	PrimaryMetaLinkURL string
-	UpdateMetaLinkURL  string
-	RepoFile           string
+	Distro   string
+	Release  string
+	Arch     string
+	RepoFile string
+	// Append adds the repositories to an already existing repository file
+	Append bool
 }
 
 func (r *RepoInit) Init() error {
+	repositories, err := DistroRepositories(r.Distro, r.Release, r.Arch)
+	if err != nil {
+		return err
+	}
+	return writeRepoFile(r.RepoFile, repositories, r.Append)
+}
+
+// RepoFileInit creates a repository file from existing dnf/yum .repo files
+type RepoFileInit struct {
+	RepoFiles  []string
+	Releasever string
+	Arch       string
+	RepoFile   string
+	// Append adds the repositories to an already existing repository file
+	Append bool
+}
+
+func (r *RepoFileInit) Init() error {
+	repositories, err := LoadRepoFiles(r.RepoFiles, r.Releasever, r.Arch)
+	if err != nil {
+		return err
+	}
+	return writeRepoFile(r.RepoFile, repositories, r.Append)
+}
 
-	_, err := os.Stat(r.RepoFile)
+func NewRepoFileInit(repoFiles []string, releasever string, arch string, repoFile string) *RepoFileInit {
+	return &RepoFileInit{
+		RepoFiles:  repoFiles,
+		Releasever: releasever,
+		Arch:       arch,
+		RepoFile:   repoFile,
+	}
+}
+
+// writeRepoFile writes the repositories to a new repository file, or adds them to an existing one if append is set
+func writeRepoFile(file string, repositories []bazeldnf.Repository, append bool) error {
+	repos := &bazeldnf.Repositories{}
+	_, err := os.Stat(file)
 	if !os.IsNotExist(err) {
-		return fmt.Errorf("repository file %s already exists.", r.RepoFile)
+		if !append {
+			return fmt.Errorf("repository file %s already exists.", file)
+		}
+		repos, err = LoadRepoFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to load existing repository file %s: %v", file, err)
+		}
 	}
-	repos := &bazeldnf.Repositories{
-		Repositories: []bazeldnf.Repository{
-			{
-				Name:     fmt.Sprintf("%s-%s-primary-repo", r.OS, r.Arch),
-				Disabled: false,
-				Metalink: r.PrimaryMetaLinkURL,
-				Baseurl:  "",
-				Arch:     r.Arch,
-			},
-			{
-				Name:     fmt.Sprintf("%s-%s-update-repo", r.OS, r.Arch),
-				Disabled: false,
-				Metalink: r.UpdateMetaLinkURL,
-				Baseurl:  "",
-				Arch:     r.Arch,
-			},
-		},
+	if err := addRepositories(repos, repositories); err != nil {
+		return err
 	}
 	data, err := yaml.Marshal(repos)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(r.RepoFile, data, 0660)
+	return ioutil.WriteFile(file, data, 0660)
+}
+
+// addRepositories appends the given repositories and rejects name clashes with already existing ones
+func addRepositories(repos *bazeldnf.Repositories, repositories []bazeldnf.Repository) error {
+	existing := map[string]bool{}
+	for _, repo := range repos.Repositories {
+		existing[repo.Name] = true
+	}
+	for _, repo := range repositories {
+		if existing[repo.Name] {
+			return fmt.Errorf("repository %s already exists", repo.Name)
+		}
+		existing[repo.Name] = true
+		repos.Repositories = append(repos.Repositories, repo)
+	}
+	return nil
 }
 
 func NewRemoteInit(os string, arch string, repoFile string) *RepoInit {
-	os = strings.TrimPrefix(os, "f")
+	return NewDistroInit("fedora", os, arch, repoFile)
+}
+
+func NewDistroInit(distro string, release string, arch string, repoFile string) *RepoInit {
 	return &RepoInit{
-		OS:                 os,
-		Arch:               arch,
-		RepoFile:           repoFile,
-		PrimaryMetaLinkURL: fmt.Sprintf("https://mirrors.fedoraproject.org/metalink?repo=fedora-%s&arch=%s", os, arch),
-		UpdateMetaLinkURL:  fmt.Sprintf("https://mirrors.fedoraproject.org/metalink?repo=updates-released-f%s&arch=%s", os, arch),
+		Distro:   distro,
+		Release:  release,
+		Arch:     arch,
+		RepoFile: repoFile,
 	}
 }
 
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScanDirectory reads the headers of all RPMs in the directory of a local repository and its subdirectories and
+// returns them like the primary metadata of a remote repository. The packages are located relative to the
+// preferred mirrors of the repository, e.g. where the RPMs get published, or to a file:// mirror of the directory.
+func ScanDirectory(repo *bazeldnf.Repository) (*api.Repository, error) {
+	dir, err := filepath.Abs(repo.Directory)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".rpm") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directory %s of %s: %v", dir, repo.Name, err)
+	}
+	sort.Strings(files)
+
+	repository := &api.Repository{}
+	for _, file := range files {
+		pkg, err := readPackageFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		href, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		pkg.Location.Href = filepath.ToSlash(href)
+		pkg.Repository = repo
+		repository.Packages = append(repository.Packages, *pkg)
+	}
+	log.Infof("Loaded %d packages from directory %s of %s", len(repository.Packages), dir, repo.Name)
+
+	if len(repo.Mirrors) == 0 && len(repo.PreferredMirrors) > 0 {
+		repo.Mirrors = repo.PreferredMirrors
+	} else if len(repo.Mirrors) == 0 {
+		repo.Mirrors = []string{"file://" + filepath.ToSlash(dir) + "/"}
+	}
+	return repository, nil
+}
+
+// readPackageFile reads the header of a RPM and records the checksum and the size of the whole file
+func readPackageFile(file string) (*api.Package, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sha := sha256.New()
+	reader := io.TeeReader(f, sha)
+	pkg, err := rpm.ReadPackage(reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pkg.Checksum = api.Checksum{Type: "sha256", Pkgid: "YES", Text: toHex(sha)}
+	pkg.Size.Package = strconv.FormatInt(info.Size(), 10)
+	pkg.Time.File = strconv.FormatInt(info.ModTime().Unix(), 10)
+	return pkg, nil
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestScanDirectory(t *testing.T) {
+	g := NewGomegaWithT(t)
+	repo := &bazeldnf.Repository{Name: "local", Directory: "testdata/rpms"}
+	primary, err := ScanDirectory(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages).To(HaveLen(2))
+
+	dir, err := filepath.Abs("testdata/rpms")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repo.Mirrors).To(Equal([]string{"file://" + dir + "/"}))
+
+	epoch := primary.Packages[0]
+	g.Expect(epoch.String()).To(Equal("one-epoch-1:0.1-1"))
+	g.Expect(epoch.Arch).To(Equal("x86_64"))
+	g.Expect(epoch.Location.Href).To(Equal("one-epoch-0.1-1.x86_64.rpm"))
+	g.Expect(epoch.Repository).To(Equal(repo))
+	g.Expect(epoch.Checksum.Type).To(Equal("sha256"))
+	g.Expect(epoch.Checksum.Text).To(HaveLen(64))
+
+	simple := primary.Packages[1]
+	g.Expect(simple.String()).To(Equal("simple-0:1.0.1-1"))
+	g.Expect(simple.Arch).To(Equal("i386"))
+	g.Expect(simple.Format.Provides.Entries).To(ContainElement(api.Entry{Name: "simple", Flags: "EQ", Epoch: "0", Ver: "1.0.1", Rel: "1"}))
+	g.Expect(simple.Format.Files).To(ConsistOf(
+		api.ProvidedFile{Text: "/config"},
+		api.ProvidedFile{Text: "/dir", Type: "dir"},
+		api.ProvidedFile{Text: "/normal"},
+	))
+	info, err := os.Stat("testdata/rpms/simple-1.0.1-1.i386.rpm")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(simple.Size.Package).To(Equal(strconv.FormatInt(info.Size(), 10)))
+
+	// published RPMs are downloaded from the preferred mirrors instead of the local directory
+	published := &bazeldnf.Repository{Name: "local", Directory: "testdata/rpms", PreferredMirrors: []string{"https://artifacts.example.com/local/"}}
+	_, err = ScanDirectory(published)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(published.Mirrors).To(Equal([]string{"https://artifacts.example.com/local/"}))
+}
+
+func TestScanDirectoryFailsOnInvalidRPMs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	dir, err := ioutil.TempDir("", "bazeldnf-local")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+	g.Expect(ioutil.WriteFile(filepath.Join(dir, "broken.rpm"), []byte("broken"), 0644)).To(Succeed())
+
+	_, err = ScanDirectory(&bazeldnf.Repository{Name: "local", Directory: dir})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("broken.rpm"))
+}
+
+func TestFetchFileURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	repoDir, err := ioutil.TempDir("", "bazeldnf-local")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(repoDir)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	// lay the metadata of a fake repository out on disk
+	baseurl := "file://" + filepath.ToSlash(repoDir)
+	fake := &fakeGetter{responses: map[string]*fakeResponse{}}
+	fake.addRepo(baseurl, "pkga", 0)
+	g.Expect(os.MkdirAll(filepath.Join(repoDir, "repodata"), 0755)).To(Succeed())
+	for url, resp := range fake.responses {
+		file := filepath.FromSlash(strings.TrimPrefix(url, "file://"))
+		g.Expect(ioutil.WriteFile(file, resp.body, 0644)).To(Succeed())
+	}
+
+	repos := []bazeldnf.Repository{{Name: "a", Baseurl: baseurl + "/"}}
+	fetcher := &RepoFetcherImpl{Getter: &getterImpl{}, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+	primary, err := fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(primary.Packages[0].Name).To(Equal("pkga"))
+
+	resp, err := (&getterImpl{}).Get(baseurl + "/missing")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+}
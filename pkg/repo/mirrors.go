@@ -0,0 +1,247 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMirrorCount is the number of mirrors taken from a metalink or a mirrorlist if not specified otherwise
+const DefaultMirrorCount = 4
+
+// MirrorsFile is the name of the file in each repository cache directory which records the probed mirror latencies
+const MirrorsFile = "mirrors.json"
+
+// DefaultProbeTimeout is the time after which a probed mirror is considered to be unreachable
+const DefaultProbeTimeout = 5 * time.Second
+
+// probeWorkers is the number of mirrors of a repository which are probed concurrently
+const probeWorkers = 8
+
+// MirrorProbe is the result of requesting the repomd.xml file of a mirror during fetch
+type MirrorProbe struct {
+	URL       string        `json:"url"`
+	Reachable bool          `json:"reachable"`
+	Latency   time.Duration `json:"latency,omitempty"`
+}
+
+type mirror struct {
+	url        string
+	location   string
+	preference int
+}
+
+// CurrentMirrors returns the mirrors which should be used for downloading the packages of the repository.
+// Preferred mirrors are always listed first. They are followed by the configured number of mirrors from the
+// metalink or the mirrorlist, ranked by location, by the latency measured during fetch and by preference. The
+// baseurl of a repository with a mirrorlist ranks after the mirrors from the list.
+func (r *CacheHelper) CurrentMirrors(repo *bazeldnf.Repository) ([]string, error) {
+	candidates := []mirror{}
+	if repo.Metalink != "" {
+		metalink, err := r.LoadMetaLink(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, url := range metalink.Repomod().Resources.URLs {
+			if url.Type != "https" {
+				continue
+			}
+			preference, _ := strconv.Atoi(url.Preference)
+			candidates = append(candidates, mirror{
+				url:        strings.TrimSuffix(url.Text, "repodata/repomd.xml"),
+				location:   url.Location,
+				preference: preference,
+			})
+		}
+	} else if repo.Mirrorlist != "" {
+		urls, err := r.LoadMirrorlist(repo)
+		if err != nil {
+			return nil, err
+		}
+		// plain mirrorlists often only contain http mirrors, only prefer https if there are any
+		https := []string{}
+		for _, url := range urls {
+			if strings.HasPrefix(url, "https://") {
+				https = append(https, url)
+			}
+		}
+		if len(https) > 0 {
+			urls = https
+		}
+		baseurl := strings.TrimSuffix(repo.Baseurl, "/") + "/"
+		for _, url := range urls {
+			if url = strings.TrimSuffix(url, "/") + "/"; url != baseurl {
+				candidates = append(candidates, mirror{url: url})
+			}
+		}
+		// fetch falls back to the baseurl if no mirror serves the metadata, so it is a mirror of last resort
+		if repo.Baseurl != "" {
+			candidates = append(candidates, mirror{url: baseurl, preference: -1})
+		}
+	} else if repo.Baseurl != "" {
+		candidates = append(candidates, mirror{url: repo.Baseurl})
+	}
+
+	probes, err := r.LoadMirrorProbes(repo)
+	if err != nil {
+		return nil, err
+	}
+	return rankMirrors(repo, candidates, probes), nil
+}
+
+// rankMirrors orders the candidates by the locations configured for the repository, then by the measured latency
+// and finally by the preference from the metalink. Mirrors which were unreachable during the last probe are dropped.
+func rankMirrors(repo *bazeldnf.Repository, candidates []mirror, probes []MirrorProbe) []string {
+	probed := map[string]*MirrorProbe{}
+	for i, probe := range probes {
+		probed[probe.URL] = &probes[i]
+	}
+	locations := map[string]int{}
+	for i, location := range repo.Locations {
+		locations[strings.ToUpper(location)] = i + 1
+	}
+	locationRank := func(m mirror) int {
+		if rank, exists := locations[strings.ToUpper(m.location)]; exists {
+			return rank
+		}
+		return len(locations) + 1
+	}
+
+	reachable := []mirror{}
+	for _, m := range candidates {
+		if probe := probed[m.url]; probe != nil && !probe.Reachable {
+			log.Debugf("Skipping mirror %s of %s, since it was unreachable", m.url, repo.Name)
+			continue
+		}
+		reachable = append(reachable, m)
+	}
+	sort.SliceStable(reachable, func(i, j int) bool {
+		if li, lj := locationRank(reachable[i]), locationRank(reachable[j]); li != lj {
+			return li < lj
+		}
+		pi, pj := probed[reachable[i].url], probed[reachable[j].url]
+		if pi != nil && pj != nil && pi.Latency != pj.Latency {
+			return pi.Latency < pj.Latency
+		} else if (pi == nil) != (pj == nil) {
+			return pi != nil
+		}
+		return reachable[i].preference > reachable[j].preference
+	})
+
+	count := repo.MirrorCount
+	if count <= 0 {
+		count = DefaultMirrorCount
+	}
+	if len(reachable) > count {
+		reachable = reachable[:count]
+	}
+
+	mirrors := []string{}
+	seen := map[string]bool{}
+	add := func(url string) {
+		key := strings.TrimSuffix(url, "/")
+		if !seen[key] {
+			seen[key] = true
+			mirrors = append(mirrors, url)
+		}
+	}
+	for _, url := range repo.PreferredMirrors {
+		add(url)
+	}
+	for _, m := range reachable {
+		add(m.url)
+	}
+	return mirrors
+}
+
+// explicitMirrors keeps the order of mirrors which were listed in the repository file
+func explicitMirrors(urls []string) []mirror {
+	mirrors := []mirror{}
+	for _, url := range urls {
+		mirrors = append(mirrors, mirror{url: url})
+	}
+	return mirrors
+}
+
+// LoadMirrorProbes returns the mirror latencies measured during the last fetch, if the mirrors were probed
+func (r *CacheHelper) LoadMirrorProbes(repo *bazeldnf.Repository) ([]MirrorProbe, error) {
+	if _, err := os.Stat(filepath.Join(r.CacheDir, repo.Name, MirrorsFile)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	reader, err := r.OpenFromRepoDir(repo, MirrorsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	probes := []MirrorProbe{}
+	if err := json.NewDecoder(reader).Decode(&probes); err != nil {
+		return nil, fmt.Errorf("failed to decode mirror probes of %s: %v", repo.Name, err)
+	}
+	return probes, nil
+}
+
+// probeMirrors requests the repomd.xml file from all given mirrors and records how long each of them took
+func (r *RepoFetcherImpl) probeMirrors(staging *StagingHelper, repo *bazeldnf.Repository, repomdURLs []string) error {
+	timeout := r.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	probes := make([]MirrorProbe, len(repomdURLs))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < probeWorkers && w < len(repomdURLs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				probes[i] = r.probeMirror(repomdURLs[i], timeout)
+			}
+		}()
+	}
+	for i := range repomdURLs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, probe := range probes {
+		if probe.Reachable {
+			log.Infof("Mirror %s of %s answered in %v", probe.URL, repo.Name, probe.Latency)
+		} else {
+			log.Infof("Mirror %s of %s is unreachable", probe.URL, repo.Name)
+		}
+	}
+	data, err := json.MarshalIndent(probes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode mirror probes of %s: %v", repo.Name, err)
+	}
+	return staging.WriteToRepoDir(repo, bytes.NewReader(data), MirrorsFile)
+}
+
+func (r *RepoFetcherImpl) probeMirror(repomdURL string, timeout time.Duration) MirrorProbe {
+	probe := MirrorProbe{URL: strings.TrimSuffix(repomdURL, "repodata/repomd.xml")}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := r.Getter.GetWithContext(ctx, repomdURL)
+	if err != nil {
+		return probe
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		probe.Reachable = true
+		probe.Latency = time.Since(start)
+	}
+	return probe
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestRankMirrors(t *testing.T) {
+	candidates := []mirror{
+		{url: "https://a/", location: "DE", preference: 90},
+		{url: "https://b/", location: "GB", preference: 99},
+		{url: "https://c/", location: "PL", preference: 98},
+		{url: "https://d/", location: "SK", preference: 94},
+		{url: "https://e/", location: "DE", preference: 95},
+	}
+	tests := []struct {
+		name     string
+		repo     bazeldnf.Repository
+		probes   []MirrorProbe
+		expected []string
+	}{
+		{
+			name:     "should rank by preference and keep the default number of mirrors",
+			expected: []string{"https://b/", "https://c/", "https://e/", "https://d/"},
+		},
+		{
+			name:     "should respect the mirror count",
+			repo:     bazeldnf.Repository{MirrorCount: 2},
+			expected: []string{"https://b/", "https://c/"},
+		},
+		{
+			name:     "should prefer the configured locations",
+			repo:     bazeldnf.Repository{Locations: []string{"sk", "de"}},
+			expected: []string{"https://d/", "https://e/", "https://a/", "https://b/"},
+		},
+		{
+			name: "should prefer fast mirrors and drop unreachable ones",
+			probes: []MirrorProbe{
+				{URL: "https://a/", Reachable: true, Latency: 20 * time.Millisecond},
+				{URL: "https://b/", Reachable: false},
+				{URL: "https://c/", Reachable: true, Latency: 10 * time.Millisecond},
+			},
+			expected: []string{"https://c/", "https://a/", "https://e/", "https://d/"},
+		},
+		{
+			name:     "should always list preferred mirrors first",
+			repo:     bazeldnf.Repository{PreferredMirrors: []string{"https://cache/", "https://c"}, MirrorCount: 2},
+			expected: []string{"https://cache/", "https://c", "https://b/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			g.Expect(rankMirrors(&tt.repo, candidates, tt.probes)).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestCurrentMirrorsFromMetalink(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-mirrors")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+	metalink, err := ioutil.ReadFile("testdata/metalink")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(os.MkdirAll(filepath.Join(cacheDir, "updates"), 0770)).To(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(cacheDir, "updates", "metalink"), metalink, 0660)).To(Succeed())
+
+	helper := &CacheHelper{CacheDir: cacheDir}
+	repo := &bazeldnf.Repository{Name: "updates", Metalink: "https://metalink", Locations: []string{"SK"}, MirrorCount: 2}
+	mirrors, err := helper.CurrentMirrors(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(mirrors).To(Equal([]string{
+		"https://ftp.upjs.sk/pub/fedora/linux/updates/32/Everything/x86_64/",
+		"https://mirror.sucs.org/pub/linux/fedora/updates/32/Everything/x86_64/",
+	}))
+}
+
+func TestCurrentMirrorsFromMirrorlistWithBaseurl(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-mirrors")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+	g.Expect(os.MkdirAll(filepath.Join(cacheDir, "baseos"), 0770)).To(Succeed())
+	g.Expect(ioutil.WriteFile(filepath.Join(cacheDir, "baseos", "mirrorlist"), []byte("https://a/baseos\nhttps://main/baseos/\n"), 0660)).To(Succeed())
+
+	helper := &CacheHelper{CacheDir: cacheDir}
+	repo := &bazeldnf.Repository{Name: "baseos", Mirrorlist: "https://mirrors/list", Baseurl: "https://main/baseos"}
+	mirrors, err := helper.CurrentMirrors(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	// the baseurl which fetch falls back to is listed once, after the mirrors from the mirrorlist
+	g.Expect(mirrors).To(Equal([]string{"https://a/baseos/", "https://main/baseos/"}))
+
+	repo.Baseurl = "https://vault/baseos/"
+	mirrors, err = helper.CurrentMirrors(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(mirrors).To(Equal([]string{"https://a/baseos/", "https://main/baseos/", "https://vault/baseos/"}))
+}
+
+func TestFetchProbesMirrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	repos := []bazeldnf.Repository{{Name: "a", Mirrorlist: "http://mirrors/list", MirrorCount: 2}}
+	getter := &fakeGetter{responses: map[string]*fakeResponse{}}
+	getter.responses["http://mirrors/list"] = &fakeResponse{body: []byte("http://slow/a\nhttp://down/a\nhttp://fast/a\n")}
+	getter.addRepo("http://slow/a", "pkga", 50*time.Millisecond)
+	getter.addRepo("http://fast/a", "pkga", 0)
+	getter.responses["http://down/a/repodata/repomd.xml"] = &fakeResponse{err: fmt.Errorf("connection refused")}
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}, ProbeMirrors: true}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+
+	probes, err := fetcher.CacheHelper.LoadMirrorProbes(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(probes).To(HaveLen(3))
+	_, err = fetcher.CacheHelper.CurrentPrimary(&repos[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repos[0].Mirrors).To(Equal([]string{"http://fast/a/", "http://slow/a/"}))
+}
+
+func TestProbeMirrorCancelsSlowRequests(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	fetcher := &RepoFetcherImpl{Getter: &getterImpl{}}
+	probe := fetcher.probeMirror(server.URL+"/a/repodata/repomd.xml", 50*time.Millisecond)
+	g.Expect(probe.Reachable).To(BeFalse())
+	g.Expect(probe.URL).To(Equal(server.URL + "/a/"))
+	g.Eventually(cancelled, time.Second).Should(BeClosed())
+}
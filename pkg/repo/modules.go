@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// ParseModules reads the module streams and module defaults from a modules.yaml file. Other documents, like
+// translations, are skipped.
+func ParseModules(reader io.Reader) (*api.Modules, error) {
+	modules := &api.Modules{}
+	for i, document := range splitDocuments(reader) {
+		doc := &api.ModuleDocument{}
+		if err := yaml.Unmarshal(document, doc); err != nil {
+			return nil, fmt.Errorf("failed to parse document %d of the modules metadata: %v", i, err)
+		}
+		switch doc.Document {
+		case api.ModulemdDocument:
+			stream := api.ModuleStream{}
+			if err := json.Unmarshal(doc.Data, &stream); err != nil {
+				return nil, fmt.Errorf("failed to parse module stream in document %d: %v", i, err)
+			}
+			modules.Streams = append(modules.Streams, stream)
+		case api.ModulemdDefaultsDocument:
+			defaults := api.ModuleDefaults{}
+			if err := json.Unmarshal(doc.Data, &defaults); err != nil {
+				return nil, fmt.Errorf("failed to parse module defaults in document %d: %v", i, err)
+			}
+			modules.Defaults = append(modules.Defaults, defaults)
+		}
+	}
+	return modules, nil
+}
+
+// splitDocuments splits a YAML stream into its documents, since sigs.k8s.io/yaml only handles single documents
+func splitDocuments(reader io.Reader) (documents [][]byte) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	current := &bytes.Buffer{}
+	flush := func() {
+		if len(bytes.TrimSpace(current.Bytes())) > 0 {
+			documents = append(documents, current.Bytes())
+		}
+		current = &bytes.Buffer{}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") || line == "..." {
+			flush()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+	return documents
+}
+
+// CurrentModules returns the modules metadata of a repository. Repositories without modules metadata, or whose
+// cached metadata predates modules support, have no modules.
+func (r *CacheHelper) CurrentModules(repo *bazeldnf.Repository) (*api.Modules, error) {
+	repomd := &api.Repomd{}
+	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
+		return nil, err
+	}
+	modules := repomd.File(api.ModulesFileType)
+	if modules == nil {
+		return &api.Modules{}, nil
+	}
+	name := filepath.Base(modules.Location.Href)
+	if _, err := os.Stat(filepath.Join(r.CacheDir, repo.Name, name)); os.IsNotExist(err) {
+		// caches fetched by older versions only contain the primary metadata
+		log.Warnf("Modules metadata of %s is not cached, ignoring its modules until it is fetched again", repo.Name)
+		return &api.Modules{}, nil
+	}
+	reader, err := r.OpenCompressedFromRepoDir(repo, name)
+	if err != nil {
+		return nil, fmt.Errorf("modules metadata of %s is not cached, fetch it first: %v", repo.Name, err)
+	}
+	defer reader.Close()
+	return ParseModules(reader)
+}
+
+// CurrentModulesForRepos merges the modules metadata of all repositories for the given architecture
+func (r *CacheHelper) CurrentModulesForRepos(repos *bazeldnf.Repositories, arch string) (*api.Modules, error) {
+	merged := &api.Modules{}
+	for i, repo := range repos.Repositories {
+		if repo.Arch != arch || repo.Disabled || repo.Directory != "" {
+			continue
+		}
+		modules, err := r.CurrentModules(&repos.Repositories[i])
+		if err != nil {
+			return nil, err
+		}
+		merged.Streams = append(merged.Streams, modules.Streams...)
+		merged.Defaults = append(merged.Defaults, modules.Defaults...)
+	}
+	return merged, nil
+}
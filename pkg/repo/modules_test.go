@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestParseModules(t *testing.T) {
+	g := NewGomegaWithT(t)
+	f, err := os.Open("testdata/modules.yaml")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer f.Close()
+	modules, err := ParseModules(f)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(modules.Streams).To(HaveLen(2))
+	g.Expect(modules.Streams[0].Name).To(Equal(api.ModuleString("nodejs")))
+	g.Expect(modules.Streams[0].Stream).To(Equal(api.ModuleString("10")))
+	g.Expect(modules.Streams[0].Version).To(Equal(int64(8030020210426100849)))
+	g.Expect(modules.Streams[0].Artifacts.RPMs).To(ConsistOf(
+		"nodejs-1:10.24.0-1.module_el8.3.0+717+fa496f1d.src",
+		"nodejs-1:10.24.0-1.module_el8.3.0+717+fa496f1d.x86_64",
+		"npm-1:6.14.11-1.10.24.0.1.module_el8.3.0+717+fa496f1d.x86_64",
+	))
+	g.Expect(modules.Streams[1].Stream).To(Equal(api.ModuleString("12")))
+	g.Expect(modules.Defaults).To(ConsistOf(api.ModuleDefaults{Module: "nodejs", Stream: "10"}))
+}
+
+func TestFetchModules(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheDir, err := ioutil.TempDir("", "bazeldnf-fetch")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(cacheDir)
+
+	compress := func(data []byte) ([]byte, string) {
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+		sum := sha256.Sum256(buf.Bytes())
+		return buf.Bytes(), hex.EncodeToString(sum[:])
+	}
+	modulesYAML, err := ioutil.ReadFile("testdata/modules.yaml")
+	g.Expect(err).ToNot(HaveOccurred())
+	primary, primarySHA := compress([]byte(`<metadata packages="1"><package type="rpm"><name>nodejs</name><arch>x86_64</arch></package></metadata>`))
+	modules, modulesSHA := compress(modulesYAML)
+	getter := &fakeGetter{responses: map[string]*fakeResponse{
+		"http://a/repodata/repomd.xml": {body: []byte(fmt.Sprintf(`<repomd>`+
+			`<data type="primary"><checksum type="sha256">%s</checksum><location href="repodata/primary.xml.gz"/></data>`+
+			`<data type="modules"><checksum type="sha256">%s</checksum><location href="repodata/modules.yaml.gz"/></data>`+
+			`</repomd>`, primarySHA, modulesSHA))},
+		"http://a/repodata/primary.xml.gz":  {body: primary},
+		"http://a/repodata/modules.yaml.gz": {body: modules},
+	}}
+	repos := []bazeldnf.Repository{{Name: "a", Baseurl: "http://a", Arch: "x86_64"}}
+	fetcher := &RepoFetcherImpl{Getter: getter, Repos: repos, CacheHelper: &CacheHelper{CacheDir: cacheDir}}
+	g.Expect(fetcher.Fetch()).To(Succeed())
+
+	merged, err := fetcher.CacheHelper.CurrentModulesForRepos(&bazeldnf.Repositories{Repositories: repos}, "x86_64")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(merged.Streams).To(HaveLen(2))
+	g.Expect(merged.Defaults).To(HaveLen(1))
+
+	// caches fetched before modules were supported have no modules
+	g.Expect(os.Remove(filepath.Join(cacheDir, "a", "modules.yaml.gz"))).To(Succeed())
+	merged, err = fetcher.CacheHelper.CurrentModulesForRepos(&bazeldnf.Repositories{Repositories: repos}, "x86_64")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(merged.Streams).To(BeEmpty())
+}
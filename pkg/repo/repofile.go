@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	log "github.com/sirupsen/logrus"
+)
+
+// repoSection contains the options of a single repository from a dnf/yum .repo file
+type repoSection struct {
+	id      string
+	options map[string]string
+}
+
+// ParseRepoFile reads the repository definitions of a dnf/yum .repo file. The variables $releasever, $basearch
+// and $arch in the options are replaced with the given values.
+func ParseRepoFile(reader io.Reader, releasever string, basearch string) (repos []bazeldnf.Repository, err error) {
+	sections, err := parseINI(reader)
+	if err != nil {
+		return nil, err
+	}
+	// $basearch has to be replaced before $arch
+	vars := [][2]string{
+		{"releasever", releasever},
+		{"basearch", basearch},
+		{"arch", basearch},
+	}
+	for _, section := range sections {
+		repo := bazeldnf.Repository{
+			Name: section.id,
+			Arch: basearch,
+		}
+		if enabled, exists := section.options["enabled"]; exists {
+			repo.Disabled = !isTrue(enabled)
+		}
+		if baseurls := splitList(substitute(section.options["baseurl"], vars)); len(baseurls) > 0 {
+			repo.Baseurl = baseurls[0]
+			if len(baseurls) > 1 {
+				repo.Mirrors = baseurls
+			}
+		}
+		repo.Metalink = substitute(section.options["metalink"], vars)
+		if mirrorlist := substitute(section.options["mirrorlist"], vars); mirrorlist != "" && repo.Metalink == "" {
+			// like dnf, treat mirrorlists which point to a metalink as metalink
+			if strings.Contains(mirrorlist, "metalink") {
+				repo.Metalink = mirrorlist
+			} else {
+				repo.Mirrorlist = mirrorlist
+			}
+		}
+		if repo.Baseurl == "" && repo.Metalink == "" && repo.Mirrorlist == "" {
+			return nil, fmt.Errorf("repository %s has neither a baseurl, a metalink nor a mirrorlist", section.id)
+		}
+		for option, value := range map[string]*int{"priority": &repo.Priority, "cost": &repo.Cost} {
+			if section.options[option] == "" {
+				continue
+			}
+			if *value, err = strconv.Atoi(section.options[option]); err != nil {
+				return nil, fmt.Errorf("repository %s has an invalid %s: %v", section.id, option, err)
+			}
+		}
+		// exclude is the old name of excludepkgs
+		if excludes := splitList(section.options["excludepkgs"] + " " + section.options["exclude"]); len(excludes) > 0 {
+			repo.ExcludePkgs = excludes
+		}
+		if includes := splitList(section.options["includepkgs"]); len(includes) > 0 {
+			repo.IncludePkgs = includes
+		}
+		if gpgkeys := splitList(substitute(section.options["gpgkey"], vars)); len(gpgkeys) > 0 {
+			repo.GPGKey = gpgkeys[0]
+			if len(gpgkeys) > 1 {
+				log.Warnf("Only using the first gpgkey %s of repository %s", gpgkeys[0], section.id)
+			}
+		}
+		repo.SSLCACert = section.options["sslcacert"]
+		repo.SSLClientCert = section.options["sslclientcert"]
+		repo.SSLClientKey = section.options["sslclientkey"]
+		repo.Username = credentialReference(section.id, "username", section.options["username"])
+		repo.Password = credentialReference(section.id, "password", section.options["password"])
+		if urls := repo.Baseurl + repo.Metalink + repo.Mirrorlist; strings.Contains(urls, "$") {
+			log.Warnf("Repository %s contains unknown variables: %s", section.id, urls)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// credentialReference keeps credentials which are read from the environment and replaces literal ones with a
+// reference to an environment variable, so that secrets don't end up in the generated repo.yaml
+func credentialReference(id string, option string, value string) string {
+	if value == "" || isEnvReference(value) {
+		return value
+	}
+	name := "BAZELDNF_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(id, "_")) + "_" + strings.ToUpper(option)
+	log.Warnf("Not writing the %s of repository %s, export it as %s before using the repository", option, id, name)
+	return "${" + name + "}"
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// LoadRepoFiles reads the repository definitions of multiple dnf/yum .repo files
+func LoadRepoFiles(files []string, releasever string, basearch string) (repos []bazeldnf.Repository, err error) {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		parsed, err := ParseRepoFile(f, releasever, basearch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+		repos = append(repos, parsed...)
+	}
+	return repos, nil
+}
+
+// parseINI reads the sections of an INI file. Indented lines continue the value of the previous option.
+func parseINI(reader io.Reader) (sections []*repoSection, err error) {
+	scanner := bufio.NewScanner(reader)
+	var section *repoSection
+	key := ""
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if section != nil && key != "" && (line[0] == ' ' || line[0] == '\t') {
+			section.options[key] = section.options[key] + "\n" + trimmed
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = &repoSection{id: strings.TrimSpace(trimmed[1 : len(trimmed)-1]), options: map[string]string{}}
+			if section.id != "main" {
+				sections = append(sections, section)
+			}
+			key = ""
+			continue
+		}
+		fields := strings.SplitN(trimmed, "=", 2)
+		if len(fields) != 2 || section == nil {
+			return nil, fmt.Errorf("invalid line %d: %s", lineNumber, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(fields[0]))
+		section.options[key] = strings.TrimSpace(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// substitute replaces $var and ${var} with the given values in the given order
+func substitute(value string, vars [][2]string) string {
+	for _, v := range vars {
+		value = strings.ReplaceAll(value, "${"+v[0]+"}", v[1])
+		value = strings.ReplaceAll(value, "$"+v[0], v[1])
+	}
+	return value
+}
+
+// splitList splits an option with multiple values separated by whitespace or commas
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+func isTrue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "yes", "true", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestParseRepoFile(t *testing.T) {
+	g := NewGomegaWithT(t)
+	f, err := os.Open("testdata/example.repo")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer f.Close()
+	repos, err := ParseRepoFile(f, "34", "x86_64")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repos).To(Equal([]bazeldnf.Repository{
+		{
+			Name:     "fedora",
+			Arch:     "x86_64",
+			Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-34&arch=x86_64",
+			GPGKey:   "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-34-x86_64",
+		},
+		{
+			Name:     "fedora-source",
+			Arch:     "x86_64",
+			Disabled: true,
+			Baseurl:  "http://mirror.one/fedora/34/source",
+			Mirrors:  []string{"http://mirror.one/fedora/34/source", "http://mirror.two/fedora/34/source"},
+			GPGKey:   "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-34-primary",
+		},
+		{
+			Name:     "updates",
+			Arch:     "x86_64",
+			Metalink: "https://mirrors.fedoraproject.org/metalink?repo=updates-released-f34&arch=x86_64",
+		},
+		{
+			Name:        "third-party",
+			Arch:        "x86_64",
+			Mirrorlist:  "http://mirrors.example.com/mirrorlist?release=34&arch=x86_64",
+			Priority:    10,
+			Cost:        500,
+			ExcludePkgs: []string{"kernel*", "glibc.i686"},
+			IncludePkgs: []string{"kernel*", "glibc*"},
+		},
+		{
+			Name:          "satellite",
+			Arch:          "x86_64",
+			Baseurl:       "https://satellite.example.com/pulp/content/x86_64/os/",
+			SSLCACert:     "/etc/rhsm/ca/katello-server-ca.pem",
+			SSLClientCert: "/etc/pki/entitlement/1234.pem",
+			SSLClientKey:  "/etc/pki/entitlement/1234-key.pem",
+			Username:      "${BAZELDNF_SATELLITE_USERNAME}",
+			Password:      "${SATELLITE_PASSWORD}",
+		},
+		{
+			Name:     "pulp-appstream",
+			Arch:     "x86_64",
+			Baseurl:  "https://pulp.example.com/appstream/",
+			Username: "$PULP_USERNAME",
+			Password: "${BAZELDNF_PULP_APPSTREAM_PASSWORD}",
+		},
+	}))
+	// literal secrets must not end up in the generated repo.yaml
+	g.Expect(fmt.Sprintf("%v", repos)).ToNot(ContainSubstring("hunter2"))
+}
+
+func TestParseRepoFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "should fail on repos without urls",
+			content: "[empty]\nname=empty\n",
+			err:     "repository empty has neither a baseurl, a metalink nor a mirrorlist",
+		},
+		{
+			name:    "should fail on invalid priorities",
+			content: "[a]\nbaseurl=http://a\npriority=high\n",
+			err:     "repository a has an invalid priority: strconv.Atoi: parsing \"high\": invalid syntax",
+		},
+		{
+			name:    "should fail on options outside of sections",
+			content: "baseurl=http://a\n",
+			err:     "invalid line 1: baseurl=http://a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			_, err := ParseRepoFile(strings.NewReader(tt.content), "34", "x86_64")
+			g.Expect(err).To(MatchError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,5 @@
+/*
+The richdep package parses rich (boolean) RPM dependencies like "(foo if bar)" or "(a >= 1.2 or b)", following the
+grammar described in https://rpm-software-management.github.io/rpm/manual/boolean_dependencies.html.
+*/
+package richdep
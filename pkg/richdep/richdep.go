@@ -0,0 +1,293 @@
+package richdep
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
+)
+
+type Operator string
+
+const (
+	OpAnd     Operator = "and"
+	OpOr      Operator = "or"
+	OpIf      Operator = "if"
+	OpUnless  Operator = "unless"
+	OpWith    Operator = "with"
+	OpWithout Operator = "without"
+	opElse             = "else"
+)
+
+var operators = map[string]Operator{
+	"and":     OpAnd,
+	"or":      OpOr,
+	"if":      OpIf,
+	"unless":  OpUnless,
+	"with":    OpWith,
+	"without": OpWithout,
+}
+
+var comparators = map[string]string{
+	"<":  "LT",
+	"<=": "LE",
+	"=":  "EQ",
+	"==": "EQ",
+	">=": "GE",
+	">":  "GT",
+}
+
+var flagSymbols = map[string]string{
+	"LT": "<",
+	"LE": "<=",
+	"EQ": "=",
+	"GE": ">=",
+	"GT": ">",
+}
+
+// Dependency is a node of a parsed rich dependency. Leaf nodes carry a simple Entry, all other nodes an Operator
+// with its operands. The operands of "if" and "unless" are the consequence, the condition and an optional "else"
+// alternative, in that order.
+type Dependency struct {
+	Op       Operator
+	Operands []*Dependency
+	Entry    *api.Entry
+}
+
+// IsRich returns true if the dependency name is a rich dependency like "(foo if bar)"
+func IsRich(name string) bool {
+	return strings.HasPrefix(name, "(")
+}
+
+// Parse parses rich dependencies according to the rpm boolean dependency grammar
+func Parse(dep string) (*Dependency, error) {
+	p := &parser{text: dep}
+	p.skipSpace()
+	if !p.peek('(') {
+		return nil, fmt.Errorf("rich dependency %q must start with '('", dep)
+	}
+	d, err := p.parseRich()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rich dependency %q: %v", dep, err)
+	}
+	p.skipSpace()
+	if !p.end() {
+		return nil, fmt.Errorf("failed to parse rich dependency %q: unexpected trailing content at position %d", dep, p.pos)
+	}
+	return d, nil
+}
+
+// Candidates returns all simple dependencies which may have to be installed to satisfy the rich dependency.
+// Conditions of "if" and "unless" and the excluded part of "without" are not part of the result, since they never
+// pull in additional packages.
+func (d *Dependency) Candidates() (entries []api.Entry) {
+	if d.Entry != nil {
+		return []api.Entry{*d.Entry}
+	}
+	switch d.Op {
+	case OpIf, OpUnless:
+		entries = append(entries, d.Operands[0].Candidates()...)
+		if len(d.Operands) == 3 {
+			entries = append(entries, d.Operands[2].Candidates()...)
+		}
+	case OpWithout:
+		entries = append(entries, d.Operands[0].Candidates()...)
+	default:
+		for _, op := range d.Operands {
+			entries = append(entries, op.Candidates()...)
+		}
+	}
+	return entries
+}
+
+func (d *Dependency) String() string {
+	if d.Entry != nil {
+		return FormatEntry(*d.Entry)
+	}
+	parts := []string{}
+	for i, op := range d.Operands {
+		if i == 2 && (d.Op == OpIf || d.Op == OpUnless) {
+			parts = append(parts, opElse)
+		} else if i > 0 {
+			parts = append(parts, string(d.Op))
+		}
+		parts = append(parts, op.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// FormatEntry returns a simple dependency in the notation used by rpm, like "foo >= 0:1.2-3"
+func FormatEntry(entry api.Entry) string {
+	symbol, exists := flagSymbols[entry.Flags]
+	if !exists {
+		return entry.Name
+	}
+	v := api.Version{Epoch: entry.Epoch, Ver: entry.Ver, Rel: entry.Rel}
+	return fmt.Sprintf("%s %s %s", entry.Name, symbol, v.String())
+}
+
+type parser struct {
+	text string
+	pos  int
+}
+
+func (p *parser) end() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *parser) peek(c byte) bool {
+	return !p.end() && p.text[p.pos] == c
+}
+
+func (p *parser) skipSpace() {
+	for !p.end() && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if !p.peek(c) {
+		if p.end() {
+			return fmt.Errorf("expected '%c' but reached the end", c)
+		}
+		return fmt.Errorf("expected '%c' at position %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// word reads everything up to the next whitespace or parenthesis
+func (p *parser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.end() && !unicode.IsSpace(rune(p.text[p.pos])) && p.text[p.pos] != '(' && p.text[p.pos] != ')' {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// peekWord returns the next word without consuming it
+func (p *parser) peekWord() string {
+	pos := p.pos
+	w := p.word()
+	p.pos = pos
+	return w
+}
+
+// name reads a dependency name. Names may contain balanced parentheses like "perl(Foo::Bar)".
+func (p *parser) name() string {
+	p.skipSpace()
+	start := p.pos
+	depth := 0
+	for !p.end() {
+		c := p.text[p.pos]
+		if unicode.IsSpace(rune(c)) && depth == 0 {
+			break
+		} else if c == '(' {
+			depth++
+		} else if c == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+func (p *parser) parseRich() (*Dependency, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek(')') {
+		p.pos++
+		return left, nil
+	}
+
+	w := p.word()
+	op, exists := operators[w]
+	if !exists {
+		return nil, fmt.Errorf("unknown operator %q", w)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	d := &Dependency{Op: op, Operands: []*Dependency{left, right}}
+
+	switch op {
+	case OpAnd, OpOr, OpWith:
+		for {
+			p.skipSpace()
+			if p.end() || p.peek(')') {
+				break
+			}
+			if w := p.word(); w != string(op) {
+				return nil, fmt.Errorf("operator %q can't be mixed with %q without parentheses", w, op)
+			}
+			next, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			d.Operands = append(d.Operands, next)
+		}
+	case OpIf, OpUnless:
+		p.skipSpace()
+		if !p.end() && !p.peek(')') {
+			if w := p.word(); w != opElse {
+				return nil, fmt.Errorf("expected %q but got %q", opElse, w)
+			}
+			alternative, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			d.Operands = append(d.Operands, alternative)
+		}
+	}
+
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (p *parser) parseOperand() (*Dependency, error) {
+	p.skipSpace()
+	if p.end() {
+		return nil, fmt.Errorf("expected a dependency but reached the end")
+	}
+	if p.peek('(') {
+		return p.parseRich()
+	}
+	name := p.name()
+	if name == "" {
+		return nil, fmt.Errorf("expected a dependency at position %d", p.pos)
+	}
+	if _, isOperator := operators[name]; isOperator || name == opElse {
+		return nil, fmt.Errorf("expected a dependency but got operator %q", name)
+	}
+	entry := &api.Entry{Name: name}
+	if flags, exists := comparators[p.peekWord()]; exists {
+		p.word()
+		version := p.word()
+		if version == "" {
+			return nil, fmt.Errorf("missing version for %s", name)
+		}
+		entry.Flags = flags
+		v, err := rpm.ParseEVR(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q for %s: %v", version, name, err)
+		}
+		entry.Epoch, entry.Ver, entry.Rel = v.Epoch, v.Ver, v.Rel
+	}
+	return &Dependency{Entry: entry}, nil
+}
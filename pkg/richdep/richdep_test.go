@@ -0,0 +1,69 @@
+package richdep
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		dep        string
+		expected   string
+		candidates []string
+		fail       bool
+	}{
+		{name: "should parse and", dep: "(a and b)", expected: "(a and b)", candidates: []string{"a", "b"}},
+		{name: "should parse chained or", dep: "(a or b or c)", expected: "(a or b or c)", candidates: []string{"a", "b", "c"}},
+		{name: "should parse if", dep: "(a if b)", expected: "(a if b)", candidates: []string{"a"}},
+		{name: "should parse if else", dep: "(a if b else c)", expected: "(a if b else c)", candidates: []string{"a", "c"}},
+		{name: "should parse unless", dep: "(a unless b)", expected: "(a unless b)", candidates: []string{"a"}},
+		{name: "should parse unless else", dep: "(a unless b else c)", expected: "(a unless b else c)", candidates: []string{"a", "c"}},
+		{name: "should parse with", dep: "(a with b)", expected: "(a with b)", candidates: []string{"a", "b"}},
+		{name: "should parse without", dep: "(a without b)", expected: "(a without b)", candidates: []string{"a"}},
+		{name: "should parse versions", dep: "(foo >= 1:2.3-4 or bar < 5)", expected: "(foo >= 1:2.3-4 or bar < 0:5)", candidates: []string{"foo", "bar"}},
+		{name: "should parse nested expressions", dep: "((a and b) or (c if (d or e)))", expected: "((a and b) or (c if (d or e)))", candidates: []string{"a", "b", "c"}},
+		{name: "should parse names with parentheses", dep: "(perl(Foo::Bar) if libc.so.6(GLIBC_2.2.5)(64bit))", expected: "(perl(Foo::Bar) if libc.so.6(GLIBC_2.2.5)(64bit))", candidates: []string{"perl(Foo::Bar)"}},
+		{name: "should tolerate additional whitespace", dep: " ( a   and  ( b or c ) ) ", expected: "(a and (b or c))", candidates: []string{"a", "b", "c"}},
+		{name: "should unwrap single dependencies", dep: "(a)", expected: "a", candidates: []string{"a"}},
+		{name: "should fail on mixed operators", dep: "(a and b or c)", fail: true},
+		{name: "should fail on unknown operators", dep: "(a nand b)", fail: true},
+		{name: "should fail on unbalanced parentheses", dep: "(a and (b or c)", fail: true},
+		{name: "should fail on trailing content", dep: "(a and b) c", fail: true},
+		{name: "should fail on missing operands", dep: "(a and)", fail: true},
+		{name: "should fail on missing versions", dep: "(a >= )", fail: true},
+		{name: "should fail on invalid versions", dep: "(a >= x:1.0 and b)", fail: true},
+		{name: "should fail on chained without", dep: "(a without b without c)", fail: true},
+		{name: "should fail on simple dependencies", dep: "a", fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			d, err := Parse(tt.dep)
+			if tt.fail {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(d.String()).To(Equal(tt.expected))
+			names := []string{}
+			for _, c := range d.Candidates() {
+				names = append(names, c.Name)
+			}
+			g.Expect(names).To(Equal(tt.candidates))
+		})
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	g := NewGomegaWithT(t)
+	d, err := Parse("(foo >= 1:2.3-4.fc32 and bar = 5.1 and baz)")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(d.Candidates()).To(Equal([]api.Entry{
+		{Name: "foo", Flags: "GE", Epoch: "1", Ver: "2.3", Rel: "4.fc32"},
+		{Name: "bar", Flags: "EQ", Epoch: "0", Ver: "5.1"},
+		{Name: "baz"},
+	}))
+}
@@ -0,0 +1,30 @@
+package rpm
+
+// multilibArches contains for every primary architecture the architectures whose packages can be installed next to
+// the packages of the primary architecture
+var multilibArches = map[string][]string{
+	"x86_64": {"i686"},
+}
+
+// CompatibleArches returns the architectures whose packages can be installed on the given architecture. Packages of
+// multilib architectures like i686 on x86_64 are only included if multilib is true.
+func CompatibleArches(arch string, multilib bool) []string {
+	arches := []string{"noarch", arch}
+	if multilib {
+		arches = append(arches, multilibArches[arch]...)
+	}
+	return arches
+}
+
+// IsMultilibArch returns true if packages of the given architecture can be installed next to the packages of another
+// primary architecture
+func IsMultilibArch(arch string) bool {
+	for _, arches := range multilibArches {
+		for _, a := range arches {
+			if a == arch {
+				return true
+			}
+		}
+	}
+	return false
+}
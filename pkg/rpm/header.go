@@ -0,0 +1,188 @@
+package rpm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/sassoftware/go-rpmutils"
+)
+
+// dependency tags which are not known to go-rpmutils
+const (
+	conflictFlags     = 1053
+	conflictName      = 1054
+	conflictVersion   = 1055
+	recommendName     = 5046
+	recommendVersion  = 5047
+	recommendFlags    = 5048
+	suggestName       = 5049
+	suggestVersion    = 5050
+	suggestFlags      = 5051
+	supplementName    = 5052
+	supplementVersion = 5053
+	supplementFlags   = 5054
+	enhanceName       = 5055
+	enhanceVersion    = 5056
+	enhanceFlags      = 5057
+)
+
+// rpmlib requirements are only relevant for rpm itself and are not part of repository metadata
+const senseRPMLib = 1 << 24
+
+// file type bits of the file modes in rpm headers
+const (
+	fileTypeMask = 0170000
+	fileTypeDir  = 0040000
+)
+
+// ReadPackage reads the header of a RPM and returns its metadata like it would be listed in the primary metadata
+// of a repository. Unlike the primary metadata, all files of the package are listed. Checksum, size and location
+// of the RPM file itself have to be filled in by the caller.
+func ReadPackage(reader io.Reader) (*api.Package, error) {
+	header, err := rpmutils.ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	nevra, err := header.GetNEVRA()
+	if err != nil {
+		return nil, err
+	}
+	pkg := &api.Package{
+		Type: "rpm",
+		Name: nevra.Name,
+		Arch: nevra.Arch,
+		Version: api.Version{
+			Epoch: nevra.Epoch,
+			Ver:   nevra.Version,
+			Rel:   nevra.Release,
+		},
+	}
+	if header.HasTag(rpmutils.SOURCERPM) {
+		pkg.Format.Sourcerpm, _ = header.GetString(rpmutils.SOURCERPM)
+	} else {
+		// only source RPMs lack the reference to their source RPM
+		pkg.Arch = "src"
+	}
+	pkg.Summary = optionalString(header, rpmutils.SUMMARY)
+	pkg.Description = optionalString(header, rpmutils.DESCRIPTION)
+	pkg.Packager = optionalString(header, rpmutils.PACKAGER)
+	pkg.URL = optionalString(header, rpmutils.URL)
+	pkg.Format.License = optionalString(header, rpmutils.LICENSE)
+	pkg.Format.Vendor = optionalString(header, rpmutils.VENDOR)
+	pkg.Format.Group = optionalString(header, rpmutils.GROUP)
+	pkg.Format.Buildhost = optionalString(header, rpmutils.BUILDHOST)
+	if buildtime, err := header.GetInt(rpmutils.BUILDTIME); err == nil {
+		pkg.Time.Build = strconv.Itoa(buildtime)
+	}
+	if size, err := header.InstalledSize(); err == nil {
+		pkg.Size.Installed = strconv.FormatInt(size, 10)
+	}
+	if size, err := header.PayloadSize(); err == nil {
+		pkg.Size.Archive = strconv.FormatInt(size, 10)
+	}
+
+	dependencies := []struct {
+		target                       *api.Dependencies
+		nameTag, versionTag, flagTag int
+	}{
+		{&pkg.Format.Provides, rpmutils.PROVIDENAME, rpmutils.PROVIDEVERSION, rpmutils.PROVIDEFLAGS},
+		{&pkg.Format.Requires, rpmutils.REQUIRENAME, rpmutils.REQUIREVERSION, rpmutils.REQUIREFLAGS},
+		{&pkg.Format.Conflicts, conflictName, conflictVersion, conflictFlags},
+		{&pkg.Format.Obsoletes, rpmutils.OBSOLETENAME, rpmutils.OBSOLETEVERSION, rpmutils.OBSOLETEFLAGS},
+		{&pkg.Format.Recommends, recommendName, recommendVersion, recommendFlags},
+		{&pkg.Format.Suggests, suggestName, suggestVersion, suggestFlags},
+		{&pkg.Format.Supplements, supplementName, supplementVersion, supplementFlags},
+		{&pkg.Format.Enhances, enhanceName, enhanceVersion, enhanceFlags},
+	}
+	for _, dep := range dependencies {
+		entries, err := readEntries(header, dep.nameTag, dep.versionTag, dep.flagTag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dependencies of %s: %v", pkg.String(), err)
+		}
+		dep.target.Entries = entries
+	}
+
+	files, err := header.GetFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read files of %s: %v", pkg.String(), err)
+	}
+	for _, file := range files {
+		entry := api.ProvidedFile{Text: file.Name()}
+		if file.Mode()&fileTypeMask == fileTypeDir {
+			entry.Type = "dir"
+		}
+		pkg.Format.Files = append(pkg.Format.Files, entry)
+	}
+	return pkg, nil
+}
+
+func optionalString(header *rpmutils.RpmHeader, tag int) string {
+	if !header.HasTag(tag) {
+		return ""
+	}
+	value, err := header.GetString(tag)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+func readEntries(header *rpmutils.RpmHeader, nameTag int, versionTag int, flagTag int) (entries []api.Entry, err error) {
+	if !header.HasTag(nameTag) {
+		return nil, nil
+	}
+	names, err := header.GetStrings(nameTag)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := header.GetStrings(versionTag)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := header.GetInts(flagTag)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) != len(names) || len(flags) != len(names) {
+		return nil, fmt.Errorf("inconsistent number of names, versions and flags")
+	}
+	seen := map[string]bool{}
+	for i, name := range names {
+		if flags[i]&senseRPMLib != 0 || strings.HasPrefix(name, "rpmlib(") {
+			continue
+		}
+		entry := api.Entry{Name: name, Flags: senseToFlags(flags[i])}
+		if versions[i] != "" {
+			version, err := ParseEVR(versions[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid version %q of dependency %s: %v", versions[i], name, err)
+			}
+			entry.Epoch, entry.Ver, entry.Rel = version.Epoch, version.Ver, version.Rel
+		}
+		if key := entry.String(); !seen[key] {
+			seen[key] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// senseToFlags translates the comparison bits of rpm dependency flags to the flags used in repository metadata
+func senseToFlags(sense int) string {
+	switch sense & (rpmutils.RPMSENSE_LESS | rpmutils.RPMSENSE_GREATER | rpmutils.RPMSENSE_EQUAL) {
+	case rpmutils.RPMSENSE_LESS:
+		return "LT"
+	case rpmutils.RPMSENSE_GREATER:
+		return "GT"
+	case rpmutils.RPMSENSE_EQUAL:
+		return "EQ"
+	case rpmutils.RPMSENSE_LESS | rpmutils.RPMSENSE_EQUAL:
+		return "LE"
+	case rpmutils.RPMSENSE_GREATER | rpmutils.RPMSENSE_EQUAL:
+		return "GE"
+	}
+	return ""
+}
@@ -1,6 +1,7 @@
 package rpm
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -20,6 +21,32 @@ func Compare(a api.Version, b api.Version) int {
 	return 0
 }
 
+// ParseEVR parses a version in the form [epoch:]version[-release]. The epoch defaults to "0".
+func ParseEVR(evr string) (api.Version, error) {
+	v := api.Version{Epoch: "0"}
+	if i := strings.Index(evr, ":"); i >= 0 {
+		v.Epoch = evr[:i]
+		evr = evr[i+1:]
+		if _, err := strconv.ParseUint(v.Epoch, 10, 32); err != nil {
+			return api.Version{}, fmt.Errorf("invalid epoch %q", v.Epoch)
+		}
+	}
+	v.Ver = evr
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		v.Ver, v.Rel = evr[:i], evr[i+1:]
+		if v.Rel == "" {
+			return api.Version{}, fmt.Errorf("missing release after '-'")
+		}
+	}
+	if v.Ver == "" {
+		return api.Version{}, fmt.Errorf("missing version")
+	}
+	if strings.ContainsAny(v.Ver, ":") {
+		return api.Version{}, fmt.Errorf("invalid version %q", v.Ver)
+	}
+	return v, nil
+}
+
 func compare(a string, b string) int {
 
 	// if a is empty and b is not, a is older
@@ -204,3 +204,57 @@ func TestCompare(t *testing.T) {
 		})
 	}
 }
+
+func TestCompatibleArches(t *testing.T) {
+	tests := []struct {
+		name     string
+		arch     string
+		multilib bool
+		want     []string
+	}{
+		{name: "x86_64 without multilib", arch: "x86_64", want: []string{"noarch", "x86_64"}},
+		{name: "x86_64 with multilib", arch: "x86_64", multilib: true, want: []string{"noarch", "x86_64", "i686"}},
+		{name: "aarch64 with multilib", arch: "aarch64", multilib: true, want: []string{"noarch", "aarch64"}},
+		{name: "s390x", arch: "s390x", want: []string{"noarch", "s390x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompatibleArches(tt.arch, tt.multilib); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompatibleArches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEVR(t *testing.T) {
+	tests := []struct {
+		name    string
+		evr     string
+		want    api.Version
+		wantErr bool
+	}{
+		{name: "version with epoch and release", evr: "2:1.2.3-4.fc32", want: api.Version{Epoch: "2", Ver: "1.2.3", Rel: "4.fc32"}},
+		{name: "version without epoch", evr: "1.2.3-4.fc32", want: api.Version{Epoch: "0", Ver: "1.2.3", Rel: "4.fc32"}},
+		{name: "version without release", evr: "1:1.2.3", want: api.Version{Epoch: "1", Ver: "1.2.3"}},
+		{name: "version only", evr: "1.2.3", want: api.Version{Epoch: "0", Ver: "1.2.3"}},
+		{name: "release with dashes in the version", evr: "1.2-rc1-3", want: api.Version{Epoch: "0", Ver: "1.2-rc1", Rel: "3"}},
+		{name: "empty version", evr: "", wantErr: true},
+		{name: "empty epoch", evr: ":1.2.3", wantErr: true},
+		{name: "non numeric epoch", evr: "a:1.2.3", wantErr: true},
+		{name: "epoch without version", evr: "1:", wantErr: true},
+		{name: "release without version", evr: "-4", wantErr: true},
+		{name: "empty release", evr: "1.2.3-", wantErr: true},
+		{name: "multiple epochs", evr: "1:2:1.2.3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEVR(tt.evr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEVR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEVR() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
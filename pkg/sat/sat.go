@@ -1,13 +1,16 @@
 package sat
 
 import (
+	"bytes"
 	"fmt"
-	"io"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/crillab/gophersat/explain"
+	"github.com/crillab/gophersat/solver"
 	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/policy"
+	"github.com/rmohr/bazeldnf/pkg/richdep"
 	"github.com/rmohr/bazeldnf/pkg/rpm"
 	"github.com/sirupsen/logrus"
 
@@ -26,6 +29,7 @@ const (
 // for every resource in a yum repo
 type VarContext struct {
 	Package  string
+	Arch     string
 	Provides string
 	Version  api.Version
 }
@@ -45,6 +49,35 @@ func toBFVars(vars []*Var) (bfvars []bf.Formula) {
 	return
 }
 
+// rule is a part of the formula which can be traced back to a single requirement, conflict or requested package.
+// Its selector allows identifying the rule in a minimal unsatisfiable subset of the formula.
+type rule struct {
+	selector string
+	formula  bf.Formula
+	reason   string
+}
+
+// Objective defines which solution gets picked if there are multiple ones
+type Objective string
+
+const (
+	// ObjectiveNone picks the first solution found
+	ObjectiveNone Objective = ""
+	// ObjectivePackages picks a solution with the least amount of packages
+	ObjectivePackages Objective = "packages"
+	// ObjectiveSize picks a solution with the smallest installed size
+	ObjectiveSize Objective = "size"
+)
+
+// ParseObjective validates the name of an objective
+func ParseObjective(name string) (Objective, error) {
+	switch objective := Objective(name); objective {
+	case ObjectiveNone, ObjectivePackages, ObjectiveSize:
+		return objective, nil
+	}
+	return "", fmt.Errorf("unknown objective %q, expected %q or %q", name, ObjectivePackages, ObjectiveSize)
+}
+
 type Resolver struct {
 	varsCount int
 	// provides allows accessing variables which can resolve unversioned requirement to build proper clauses
@@ -54,21 +87,34 @@ type Resolver struct {
 	// vars contain as key an exact identifier for a provided resource and the actual SAT variable as value
 	vars map[string]*Var
 
+	// bestPackages contains the newest package for every name and architecture
 	bestPackages map[string]*api.Package
-
-	ands         []bf.Formula
-	unresolvable []api.Entry
+	// obsoletedBy contains for every package the variables of the packages which obsolete it
+	obsoletedBy map[*api.Package][]*Var
+
+	ands  []bf.Formula
+	rules []*rule
+	// weak contains the variables of soft constraints which should be true if possible
+	weak         []string
+	unresolvable []string
 	nobest       bool
+	objective    Objective
+	weakDeps     bool
+	policy       *policy.Policy
 }
 
-func NewResolver(nobest bool) *Resolver {
+func NewResolver(nobest bool, objective Objective, weakDeps bool, policy *policy.Policy) *Resolver {
 	return &Resolver{
 		varsCount:    0,
 		provides:     map[string][]*Var{},
 		vars:         map[string]*Var{},
 		pkgProvides:  map[VarContext][]*Var{},
 		nobest:       nobest,
+		objective:    objective,
+		weakDeps:     weakDeps,
+		policy:       policy,
 		bestPackages: map[string]*api.Package{},
+		obsoletedBy:  map[*api.Package][]*Var{},
 	}
 }
 
@@ -78,24 +124,53 @@ func (r *Resolver) ticket() string {
 }
 
 func (r *Resolver) LoadInvolvedPackages(packages []*api.Package) error {
+	// Variables and clauses have to be generated in the same order on every run to get reproducible results
+	packages = append([]*api.Package{}, packages...)
+	sortPackages(packages)
 	// Create an index to pick the best candidates
+	newest := map[string]*api.Package{}
 	for _, pkg := range packages {
-		if r.bestPackages[pkg.Name] == nil {
-			r.bestPackages[pkg.Name] = pkg
-		} else if rpm.Compare(pkg.Version, r.bestPackages[pkg.Name].Version) == 1 {
-			r.bestPackages[pkg.Name] = pkg
+		if !r.policy.Allows(pkg) {
+			continue
+		}
+		key := bestKey(pkg.Name, pkg.Arch)
+		if r.bestPackages[key] == nil || rpm.Compare(pkg.Version, r.bestPackages[key].Version) == 1 {
+			r.bestPackages[key] = pkg
 		}
+		if newest[pkg.Name] == nil || rpm.Compare(pkg.Version, newest[pkg.Name].Version) == 1 {
+			newest[pkg.Name] = pkg
+		}
+	}
+	// Packages of different architectures can be installed next to each other, but noarch packages replace packages of
+	// any architecture and the other way around
+	isBest := func(pkg *api.Package) bool {
+		if r.bestPackages[bestKey(pkg.Name, pkg.Arch)] != pkg {
+			return false
+		}
+		if pkg.Arch == "noarch" {
+			return rpm.Compare(newest[pkg.Name].Version, pkg.Version) != 1
+		}
+		noarch := r.bestPackages[bestKey(pkg.Name, "noarch")]
+		return noarch == nil || rpm.Compare(noarch.Version, pkg.Version) != 1
 	}
 
 	if !r.nobest {
-		packages = nil
-		for _, v := range r.bestPackages {
-			packages = append(packages, v)
+		best := []*api.Package{}
+		for _, pkg := range packages {
+			// packages which are not allowed are kept to explain why they can't be picked
+			if isBest(pkg) || !r.policy.Allows(pkg) {
+				best = append(best, pkg)
+			}
 		}
+		packages = best
 	}
 	// Generate variables
+	contexts := []VarContext{}
 	for _, pkg := range packages {
 		pkgVar, resourceVars := r.explodePackageToVars(pkg)
+		if _, exists := r.pkgProvides[pkgVar.Context]; !exists {
+			contexts = append(contexts, pkgVar.Context)
+		}
 		r.pkgProvides[pkgVar.Context] = resourceVars
 		for _, v := range resourceVars {
 			r.provides[v.Context.Provides] = append(r.provides[v.Context.Provides], v)
@@ -104,7 +179,8 @@ func (r *Resolver) LoadInvolvedPackages(packages []*api.Package) error {
 	}
 	logrus.Infof("Loaded %v packages.", len(r.pkgProvides))
 	// Generate imply rules
-	for _, resourceVars := range r.pkgProvides {
+	for _, context := range contexts {
+		resourceVars := r.pkgProvides[context]
 		// Create imply rules for every package and add them to the formula
 		// one provided dependency implies all dependencies from that package
 		bfVar := bf.And(toBFVars(resourceVars)...)
@@ -112,12 +188,18 @@ func (r *Resolver) LoadInvolvedPackages(packages []*api.Package) error {
 		for _, res := range resourceVars {
 			ands = append(ands, bf.Implies(bf.Var(res.satVarName), bfVar))
 		}
+		r.ands = append(r.ands, ands...)
 		pkgVar := resourceVars[len(resourceVars)-1]
-		ands = append(ands, bf.Implies(bf.Var(pkgVar.satVarName), r.explodePackageRequires(pkgVar)))
-		if conflicts := r.explodePackageConflicts(pkgVar); conflicts != nil {
-			ands = append(ands, bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(conflicts)))
+		if reason := r.policy.Reason(pkgVar.Package); reason != "" {
+			r.addRule(bf.Not(bf.Var(pkgVar.satVarName)), reason)
+			continue
+		}
+		r.explodePackageRequires(pkgVar)
+		r.explodePackageConflicts(pkgVar)
+		r.explodePackageObsoletes(pkgVar)
+		if r.weakDeps {
+			r.explodeWeakDependencies(pkgVar)
 		}
-		r.ands = append(r.ands, ands...)
 	}
 	logrus.Infof("Generated %v variables.", len(r.vars))
 	return nil
@@ -129,19 +211,37 @@ func (r *Resolver) ConstructRequirements(packages []string) error {
 		if err != nil {
 			return err
 		}
-		logrus.Infof("Selecting %s: %v", pkgName, req.Package)
-		r.ands = append(r.ands, bf.Var(req.satVarName))
+		if obsoleting := r.resolveObsoleting(req); obsoleting != req {
+			logrus.Infof("Selecting %s: %v from %s, which obsoletes %v", pkgName, obsoleting.Package, obsoleting.Package.RepositoryName(), req.Package)
+			r.addRule(bf.Var(obsoleting.satVarName), fmt.Sprintf("%s was requested, which is obsoleted by %s", pkgName, obsoleting.Package.String()))
+			continue
+		}
+		logrus.Infof("Selecting %s: %v from %s", pkgName, req.Package, req.Package.RepositoryName())
+		if req.Context.Provides == req.Package.Name {
+			r.addRule(bf.Var(req.satVarName), fmt.Sprintf("%s was requested", req.Package.String()))
+		} else {
+			r.addRule(bf.Var(req.satVarName), fmt.Sprintf("%s was requested, which is provided by %s", pkgName, req.Package.String()))
+		}
 	}
 	return nil
 }
 
 func (res *Resolver) Resolve() (install []*api.Package, excluded []*api.Package, err error) {
-	logrus.WithField("bf", bf.And(res.ands...)).Debug("Formula to solve")
+	formula := res.formula(false)
+	logrus.WithField("bf", formula).Debug("Formula to solve")
 
 	if len(res.unresolvable) > 0 {
-		return nil, nil, fmt.Errorf("Can't satisfy %+v", res.unresolvable)
+		return nil, nil, fmt.Errorf("Can't satisfy all requirements: %s", strings.Join(res.unresolvable, "; "))
+	}
+	var vars map[string]bool
+	if res.objective == ObjectiveNone && len(res.weak) == 0 {
+		vars = bf.Solve(formula)
+	} else {
+		vars, err = res.optimize()
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	vars := bf.Solve(bf.And(res.ands...))
 
 	if len(vars) > 0 {
 		logrus.Info("Solution found.")
@@ -159,8 +259,8 @@ func (res *Resolver) Resolve() (install []*api.Package, excluded []*api.Package,
 			//fmt.Printf("%s:%s:%v\n", k, res.vars[k].Context.Provides, v)
 		}
 		for _, v := range installMap {
-			if rpm.Compare(res.bestPackages[v.Name].Version, v.Version) != 0 {
-				logrus.Infof("Picking %v instead of best candiate %v", v, res.bestPackages[v.Name])
+			if best := res.bestPackages[bestKey(v.Name, v.Arch)]; best != nil && rpm.Compare(best.Version, v.Version) != 0 {
+				logrus.Infof("Picking %v instead of best candiate %v", v, best)
 			}
 			install = append(install, v)
 		}
@@ -168,29 +268,180 @@ func (res *Resolver) Resolve() (install []*api.Package, excluded []*api.Package,
 		for _, v := range excludedMap {
 			excluded = append(excluded, v)
 		}
+		sortPackages(install)
+		sortPackages(excluded)
 		return install, excluded, nil
 	}
 	logrus.Info("No solution found.")
 	return nil, nil, fmt.Errorf("no solution found")
 }
 
-func (res *Resolver) MUS() (mus *explain.Problem, err error) {
-	logrus.Info("No solution found.")
-	r, w := io.Pipe()
+// Explain returns human-readable reasons why the requirements can't be satisfied. Unresolvable requirements are
+// reported directly, otherwise the reasons are the rules which are part of a minimal unsatisfiable subset.
+func (res *Resolver) Explain() ([]string, error) {
+	if len(res.unresolvable) > 0 {
+		return res.unresolvable, nil
+	}
+	mus, err := res.MUS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute a minimal unsatisfiable subset: %v", err)
+	}
+	reasons := []string{}
+	for _, rule := range mus {
+		reasons = append(reasons, rule.reason)
+	}
+	return reasons, nil
+}
 
-	err = bf.Dimacs(bf.And(res.ands...), w)
+// MUS returns a minimal unsatisfiable subset of the rules. Every rule is guarded by its selector, which allows
+// enabling and disabling rules via assumptions. Starting with all rules enabled, every rule which is not needed to
+// keep the formula unsatisfiable is removed.
+func (res *Resolver) MUS() (mus []*rule, err error) {
+	problem, indices, err := res.cnf(true)
 	if err != nil {
 		return nil, err
 	}
-	problem, err := explain.ParseCNF(r)
+	s := solver.New(problem)
+
+	enabled := []*rule{}
+	for _, rule := range res.rules {
+		if _, exists := indices[rule.selector]; exists {
+			enabled = append(enabled, rule)
+		}
+	}
+	assume := func(rules []*rule) solver.Status {
+		lits := []solver.Lit{}
+		for _, rule := range rules {
+			lits = append(lits, solver.IntToLit(int32(indices[rule.selector])))
+		}
+		if s.Assume(lits) == solver.Unsat {
+			return solver.Unsat
+		}
+		return s.Solve()
+	}
+	if assume(enabled) != solver.Unsat {
+		return nil, fmt.Errorf("the requirements can be satisfied")
+	}
+	for i := 0; i < len(enabled); {
+		candidates := append(append([]*rule{}, enabled[:i]...), enabled[i+1:]...)
+		if assume(candidates) == solver.Unsat {
+			enabled = candidates
+		} else {
+			i++
+		}
+	}
+	return enabled, nil
+}
+
+// optimize returns a model of the formula which minimizes the objective, or nil if the formula is not satisfiable
+func (res *Resolver) optimize() (map[string]bool, error) {
+	problem, indices, err := res.cnf(false)
 	if err != nil {
 		return nil, err
 	}
-	mus, err = problem.MUSInsertion()
+	names := []string{}
+	for name := range res.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lits := []solver.Lit{}
+	weights := []int{}
+	total := 0
+	if res.objective != ObjectiveNone {
+		for _, name := range names {
+			v := res.vars[name]
+			index, exists := indices[name]
+			if !exists || v.varType != VarTypePackage {
+				continue
+			}
+			lits = append(lits, solver.IntToLit(int32(index)))
+			weights = append(weights, res.weight(v.Package))
+			total += weights[len(weights)-1]
+		}
+	}
+	// every unsatisfied weak dependency costs more than the whole objective, so that the objective only decides
+	// between solutions which satisfy the same amount of weak dependencies
+	for _, name := range res.weak {
+		index, exists := indices[name]
+		if !exists {
+			continue
+		}
+		lits = append(lits, solver.IntToLit(int32(-index)))
+		weights = append(weights, total+1)
+	}
+	problem.SetCostFunc(lits, weights)
+	s := solver.New(problem)
+	cost := s.Minimize()
+	if cost < 0 {
+		return nil, nil
+	}
+	logrus.Infof("Found a solution with a cost of %d.", cost)
+	model := s.Model()
+	vars := map[string]bool{}
+	for name, index := range indices {
+		vars[name] = model[index-1]
+	}
+	return vars, nil
+}
+
+// weight returns the cost of installing a package. Sizes are counted in KiB to keep the sums small.
+func (res *Resolver) weight(pkg *api.Package) int {
+	if res.objective != ObjectiveSize {
+		return 1
+	}
+	size, err := strconv.Atoi(pkg.Size.Installed)
+	if err != nil || size < 1024 {
+		return 1
+	}
+	return size / 1024
+}
+
+// cnf converts the formula into a problem for the solver. The returned map contains the index of every variable.
+func (res *Resolver) cnf(guarded bool) (problem *solver.Problem, indices map[string]int, err error) {
+	buf := &bytes.Buffer{}
+	err = bf.Dimacs(res.formula(guarded), buf)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	// bf.Dimacs adds the variable names as "c name=index" comments
+	indices = map[string]int{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "c ") {
+			continue
+		}
+		i := strings.LastIndex(line, "=")
+		if i < 0 {
+			continue
+		}
+		index, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid variable comment %q: %v", line, err)
+		}
+		indices[line[2:i]] = index
+	}
+	problem, err = solver.ParseCNF(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return problem, indices, nil
+}
+
+// formula returns the conjunction of all clauses and rules. If guarded is true, every rule only applies if its
+// selector is true.
+func (r *Resolver) formula(guarded bool) bf.Formula {
+	ands := append([]bf.Formula{}, r.ands...)
+	for _, rule := range r.rules {
+		if guarded {
+			ands = append(ands, bf.Implies(bf.Var(rule.selector), rule.formula))
+		} else {
+			ands = append(ands, rule.formula)
+		}
 	}
-	return mus, nil
+	return bf.And(ands...)
+}
+
+func (r *Resolver) addRule(formula bf.Formula, reason string) {
+	r.rules = append(r.rules, &rule{selector: r.ticket(), formula: formula, reason: reason})
 }
 
 func (r *Resolver) explodePackageToVars(pkg *api.Package) (pkgVar *Var, resourceVars []*Var) {
@@ -201,6 +452,7 @@ func (r *Resolver) explodePackageToVars(pkg *api.Package) (pkgVar *Var, resource
 				varType:    VarTypePackage,
 				Context: VarContext{
 					Package:  pkg.Name,
+					Arch:     pkg.Arch,
 					Provides: pkg.Name,
 					Version:  pkg.Version,
 				},
@@ -214,6 +466,7 @@ func (r *Resolver) explodePackageToVars(pkg *api.Package) (pkgVar *Var, resource
 				varType:    VarTypeResource,
 				Context: VarContext{
 					Package:  pkg.Name,
+					Arch:     pkg.Arch,
 					Provides: p.Name,
 					Version:  pkg.Version,
 				},
@@ -234,6 +487,7 @@ func (r *Resolver) explodePackageToVars(pkg *api.Package) (pkgVar *Var, resource
 			varType:    VarTypeFile,
 			Context: VarContext{
 				Package:  pkg.Name,
+				Arch:     pkg.Arch,
 				Provides: f.Text,
 				Version:  pkg.Version,
 			},
@@ -245,31 +499,229 @@ func (r *Resolver) explodePackageToVars(pkg *api.Package) (pkgVar *Var, resource
 	return pkgVar, resourceVars
 }
 
-func (r *Resolver) explodePackageRequires(pkgVar *Var) bf.Formula {
-	var bfunique = bf.Var(pkgVar.satVarName)
-	for _, req := range pkgVar.Package.Format.Requires.Entries {
+func (r *Resolver) explodePackageRequires(pkgVar *Var) {
+	pkg := pkgVar.Package
+	for _, req := range pkg.Format.Requires.Entries {
+		if richdep.IsRich(req.Name) {
+			dep, err := richdep.Parse(req.Name)
+			if err != nil {
+				logrus.Warnf("%s has an invalid requirement: %v", pkg.String(), err)
+				r.unresolvable = append(r.unresolvable, fmt.Sprintf("%s has an invalid requirement: %v", pkg.String(), err))
+				continue
+			}
+			r.addRule(bf.Implies(bf.Var(pkgVar.satVarName), r.explodeRichRequires(dep)),
+				fmt.Sprintf("%s requires %s", pkg.String(), dep.String()))
+			continue
+		}
 		satisfies, err := r.explodeSingleRequires(req, r.provides[req.Name])
 		if err != nil {
-			r.unresolvable = append(r.unresolvable, req)
+			r.unresolvable = append(r.unresolvable, r.describeUnresolvable(pkg, req))
 			continue
 		}
 		uniqueVars := []string{}
 		for _, s := range satisfies {
 			uniqueVars = append(uniqueVars, s.satVarName)
 		}
-		bfunique = bf.And(bf.Unique(uniqueVars...), bfunique)
+		r.addRule(bf.Implies(bf.Var(pkgVar.satVarName), bf.Unique(uniqueVars...)),
+			fmt.Sprintf("%s requires %s, which is provided by %s", pkg.String(), richdep.FormatEntry(req), describePackages(satisfies)))
 	}
-	return bfunique
 }
 
-func (r *Resolver) explodePackageConflicts(pkgVar *Var) bf.Formula {
-	conflictingVars := []bf.Formula{}
+// describeUnresolvable explains why nothing satisfies a requirement of a package
+func (r *Resolver) describeUnresolvable(pkg *api.Package, req api.Entry) string {
+	providers := describeProviders(r.provides[req.Name])
+	if len(providers) == 0 {
+		return fmt.Sprintf("%s requires %s, but nothing provides %s", pkg.String(), richdep.FormatEntry(req), req.Name)
+	}
+	verb := "is"
+	if len(providers) > 1 {
+		verb = "are"
+	}
+	return fmt.Sprintf("%s requires %s, but only %s %s available", pkg.String(), richdep.FormatEntry(req), strings.Join(providers, ", "), verb)
+}
+
+// describePackages lists the packages behind the given variables
+func describePackages(vars []*Var) string {
+	descriptions := []string{}
+	seen := map[*api.Package]bool{}
+	for _, v := range vars {
+		if !seen[v.Package] {
+			seen[v.Package] = true
+			descriptions = append(descriptions, v.Package.String())
+		}
+	}
+	sort.Strings(descriptions)
+	return strings.Join(descriptions, ", ")
+}
+
+// describeProviders lists the packages behind the given variables, including the provided resource if it is not the
+// package itself
+func describeProviders(vars []*Var) (descriptions []string) {
+	seen := map[string]bool{}
+	for _, v := range vars {
+		description := v.Package.String()
+		if v.Context.Provides != v.Package.Name {
+			if v.ResourceVersion != nil && v.ResourceVersion.Ver != "" {
+				description = fmt.Sprintf("%s = %s from %s", v.Context.Provides, v.ResourceVersion.String(), description)
+			} else {
+				description = fmt.Sprintf("%s from %s", v.Context.Provides, description)
+			}
+		}
+		if !seen[description] {
+			seen[description] = true
+			descriptions = append(descriptions, description)
+		}
+	}
+	sort.Strings(descriptions)
+	return descriptions
+}
+
+// explodeWeakDependencies adds soft constraints for recommended packages and for packages which the package supplements
+func (r *Resolver) explodeWeakDependencies(pkgVar *Var) {
+	pkg := bf.Var(pkgVar.satVarName)
+	for _, recommends := range pkgVar.Package.Format.Recommends.Entries {
+		dep, err := parseDependency(recommends)
+		if err != nil {
+			logrus.Warnf("%s has an invalid recommendation: %v", pkgVar.Package.String(), err)
+			continue
+		}
+		r.addWeak(bf.Not(pkg), r.explodeRichRequires(dep))
+	}
+	for _, supplements := range pkgVar.Package.Format.Supplements.Entries {
+		dep, err := parseDependency(supplements)
+		if err != nil {
+			logrus.Warnf("%s has an invalid supplement: %v", pkgVar.Package.String(), err)
+			continue
+		}
+		r.addWeak(bf.Not(r.explodeRichRequires(dep)), pkg)
+	}
+}
+
+// addWeak adds a clause which only has to be satisfied if its new variable is true
+func (r *Resolver) addWeak(literals ...bf.Formula) {
+	name := r.ticket()
+	r.ands = append(r.ands, bf.Or(append([]bf.Formula{bf.Not(bf.Var(name))}, literals...)...))
+	r.weak = append(r.weak, name)
+}
+
+// parseDependency turns simple and rich dependencies into a richdep.Dependency
+func parseDependency(entry api.Entry) (*richdep.Dependency, error) {
+	if richdep.IsRich(entry.Name) {
+		return richdep.Parse(entry.Name)
+	}
+	return &richdep.Dependency{Entry: &entry}, nil
+}
+
+// explodeRichRequires returns a variable which is true if and only if the rich dependency is satisfied. The
+// definition of the variable is added to the formula in flat clauses, since deeply nested formulas are not
+// translated correctly into CNF by bf.
+func (r *Resolver) explodeRichRequires(dep *richdep.Dependency) bf.Formula {
+	if dep.Entry != nil {
+		return r.defineOr(r.richProviders(dep)...)
+	}
+	operands := []bf.Formula{}
+	switch dep.Op {
+	case richdep.OpWith, richdep.OpWithout:
+		return r.defineOr(r.richProviders(dep)...)
+	case richdep.OpIf, richdep.OpUnless:
+		then := r.explodeRichRequires(dep.Operands[0])
+		cond := r.explodeRichRequires(dep.Operands[1])
+		if dep.Op == richdep.OpUnless {
+			cond = bf.Not(cond)
+		}
+		if len(dep.Operands) == 2 {
+			return r.defineOr(bf.Not(cond), then)
+		}
+		alternative := r.explodeRichRequires(dep.Operands[2])
+		return r.defineAnd(r.defineOr(bf.Not(cond), then), r.defineOr(cond, alternative))
+	}
+	for _, op := range dep.Operands {
+		operands = append(operands, r.explodeRichRequires(op))
+	}
+	if dep.Op == richdep.OpOr {
+		return r.defineOr(operands...)
+	}
+	return r.defineAnd(operands...)
+}
+
+// richProviders returns one variable for every package which satisfies a simple dependency, or a "with" or
+// "without" expression, which both have to be satisfied by a single package.
+func (r *Resolver) richProviders(dep *richdep.Dependency) (providers []bf.Formula) {
+	pkgs := r.richProviderPackages(dep)
+	vars := []*Var{}
+	for _, v := range pkgs {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].satVarName < vars[j].satVarName
+	})
+	return toBFVars(vars)
+}
+
+func (r *Resolver) richProviderPackages(dep *richdep.Dependency) map[*api.Package]*Var {
+	pkgs := map[*api.Package]*Var{}
+	if dep.Entry != nil {
+		satisfies, err := r.explodeSingleRequires(*dep.Entry, r.provides[dep.Entry.Name])
+		if err != nil {
+			return pkgs
+		}
+		for _, s := range satisfies {
+			pkgs[s.Package] = s
+		}
+		return pkgs
+	}
+	switch dep.Op {
+	case richdep.OpWith:
+		pkgs = r.richProviderPackages(dep.Operands[0])
+		for _, op := range dep.Operands[1:] {
+			others := r.richProviderPackages(op)
+			for pkg := range pkgs {
+				if _, exists := others[pkg]; !exists {
+					delete(pkgs, pkg)
+				}
+			}
+		}
+	case richdep.OpWithout:
+		pkgs = r.richProviderPackages(dep.Operands[0])
+		for pkg := range r.richProviderPackages(dep.Operands[1]) {
+			delete(pkgs, pkg)
+		}
+	default:
+		logrus.Warnf("%s is not allowed inside of with or without expressions", dep.String())
+	}
+	return pkgs
+}
+
+// defineOr returns a new variable which is equivalent to the disjunction of the given literals
+func (r *Resolver) defineOr(literals ...bf.Formula) bf.Formula {
+	v := bf.Var(r.ticket())
+	r.ands = append(r.ands, bf.Or(append([]bf.Formula{bf.Not(v)}, literals...)...))
+	for _, l := range literals {
+		r.ands = append(r.ands, bf.Or(bf.Not(l), v))
+	}
+	return v
+}
+
+// defineAnd returns a new variable which is equivalent to the conjunction of the given literals
+func (r *Resolver) defineAnd(literals ...bf.Formula) bf.Formula {
+	v := bf.Var(r.ticket())
+	clause := []bf.Formula{v}
+	for _, l := range literals {
+		r.ands = append(r.ands, bf.Or(bf.Not(v), l))
+		clause = append(clause, bf.Not(l))
+	}
+	r.ands = append(r.ands, bf.Or(clause...))
+	return v
+}
+
+func (r *Resolver) explodePackageConflicts(pkgVar *Var) {
 	for _, req := range pkgVar.Package.Format.Conflicts.Entries {
 		conflicts, err := r.explodeSingleRequires(req, r.provides[req.Name])
 		if err != nil {
 			// if a conflicting resource does not exist, we don't care
 			continue
 		}
+		conflictingVars := []*Var{}
 		for _, s := range conflicts {
 			if s.Package == pkgVar.Package {
 				// don't conflict with yourself
@@ -279,29 +731,124 @@ func (r *Resolver) explodePackageConflicts(pkgVar *Var) bf.Formula {
 			if !strings.HasPrefix(s.Package.Name, "fedora-release") && !strings.HasPrefix(pkgVar.Package.String(), "fedora-release") {
 				logrus.Infof("%s conflicts with %s", s.Package.String(), pkgVar.Package.String())
 			}
-			conflictingVars = append(conflictingVars, bf.Var(s.satVarName))
+			conflictingVars = append(conflictingVars, s)
+		}
+		if len(conflictingVars) == 0 {
+			continue
 		}
+		r.addRule(bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(bf.Or(toBFVars(conflictingVars)...))),
+			fmt.Sprintf("%s conflicts with %s, which is provided by %s", pkgVar.Package.String(), richdep.FormatEntry(req), describePackages(conflictingVars)))
 	}
-	if len(conflictingVars) == 0 {
-		return nil
+}
+
+// explodePackageObsoletes makes a package and the packages it obsoletes mutually exclusive. Obsoletes only match
+// package names, not other provided resources.
+func (r *Resolver) explodePackageObsoletes(pkgVar *Var) {
+	for _, req := range pkgVar.Package.Format.Obsoletes.Entries {
+		candidates := []*Var{}
+		for _, v := range r.provides[req.Name] {
+			// packages can't obsolete other versions of themselves
+			if v.varType == VarTypePackage && v.Package.Name != pkgVar.Package.Name {
+				candidates = append(candidates, v)
+			}
+		}
+		obsoleted, err := r.explodeSingleRequires(req, candidates)
+		if err != nil {
+			// if no obsoleted package exists, we don't care
+			continue
+		}
+		for _, v := range obsoleted {
+			logrus.Debugf("%s obsoletes %s", pkgVar.Package.String(), v.Package.String())
+			r.obsoletedBy[v.Package] = append(r.obsoletedBy[v.Package], pkgVar)
+		}
+		r.addRule(bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(bf.Or(toBFVars(obsoleted)...))),
+			fmt.Sprintf("%s obsoletes %s, which matches %s", pkgVar.Package.String(), richdep.FormatEntry(req), describePackages(obsoleted)))
+	}
+}
+
+// resolveObsoleting returns the newest package which replaces the given package, following chains of obsoleting
+// packages. If the package is not obsoleted, it is returned unchanged.
+func (r *Resolver) resolveObsoleting(req *Var) *Var {
+	seen := map[*api.Package]bool{req.Package: true}
+	for {
+		var newest *Var
+		for _, v := range r.obsoletedBy[req.Package] {
+			if seen[v.Package] {
+				continue
+			}
+			if newest == nil || rpm.Compare(v.Package.Version, newest.Package.Version) == 1 {
+				newest = v
+			}
+		}
+		if newest == nil {
+			return req
+		}
+		seen[newest.Package] = true
+		req = newest
 	}
-	return bf.Or(conflictingVars...)
 }
 
 func (r *Resolver) resolveNewest(pkgName string) (*Var, error) {
 	pkgs := r.provides[pkgName]
+	if len(pkgs) == 0 {
+		pkgs = r.resolveArch(pkgName)
+	}
 	if len(pkgs) == 0 {
 		return nil, fmt.Errorf("package %s does not exist", pkgName)
 	}
+	// prefer packages which are allowed by the policy, then packages which are not multilib packages
+	rank := func(v *Var) int {
+		rank := 0
+		if r.policy.Allows(v.Package) {
+			rank += 2
+		}
+		if !rpm.IsMultilibArch(v.Package.Arch) {
+			rank++
+		}
+		return rank
+	}
 	newest := pkgs[0]
 	for _, p := range pkgs {
-		if rpm.Compare(p.Package.Version, newest.Package.Version) == 1 {
+		if rank(p) > rank(newest) {
+			newest = p
+		} else if rank(p) == rank(newest) && rpm.Compare(p.Package.Version, newest.Package.Version) == 1 {
 			newest = p
 		}
 	}
 	return newest, nil
 }
 
+// resolveArch returns the package variables for a package name with an explicit architecture like glibc.i686
+func (r *Resolver) resolveArch(pkgName string) (pkgs []*Var) {
+	i := strings.LastIndex(pkgName, ".")
+	if i < 0 {
+		return nil
+	}
+	for _, v := range r.provides[pkgName[:i]] {
+		if v.varType == VarTypePackage && v.Package.Arch == pkgName[i+1:] {
+			pkgs = append(pkgs, v)
+		}
+	}
+	return pkgs
+}
+
+func bestKey(name string, arch string) string {
+	return name + "." + arch
+}
+
+// sortPackages orders packages by name, version and architecture
+func sortPackages(packages []*api.Package) {
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		if cmp := rpm.Compare(packages[i].Version, packages[j].Version); cmp != 0 {
+			return cmp < 0
+		}
+		return packages[i].Arch < packages[j].Arch
+	})
+}
+
 func compareRequires(entryVer api.Version, flag string, provides []*Var) (accepts []*Var, err error) {
 	for _, dep := range provides {
 
@@ -360,11 +907,16 @@ func (r *Resolver) explodeSingleRequires(entry api.Entry, provides []*Var) (acce
 	}
 
 	provPerPkg := map[VarContext][]*Var{}
+	contexts := []VarContext{}
 	for _, prov := range provides {
+		if _, exists := provPerPkg[prov.Context]; !exists {
+			contexts = append(contexts, prov.Context)
+		}
 		provPerPkg[prov.Context] = append(provPerPkg[prov.Context], prov)
 	}
 
-	for _, pkgProv := range provPerPkg {
+	for _, context := range contexts {
+		pkgProv := provPerPkg[context]
 		acceptsFromPkg, err := compareRequires(entryVer, entry.Flags, pkgProv)
 		if err != nil {
 			return nil, err
@@ -3,11 +3,13 @@ package sat
 import (
 	"encoding/xml"
 	"fmt"
+	"math/rand"
 	"os"
 	"testing"
 
 	. "github.com/onsi/gomega"
 	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/policy"
 )
 
 func TestRecursive(t *testing.T) {
@@ -22,7 +24,7 @@ func TestRecursive(t *testing.T) {
 	for _, pkg := range repo.Packages {
 		t.Run(fmt.Sprintf("find solution for %s", pkg.Name), func(t *testing.T) {
 			g := NewGomegaWithT(t)
-			resolver := NewResolver(false)
+			resolver := NewResolver(false, ObjectiveNone, false, &policy.Policy{})
 			packages := []*api.Package{}
 			for i, _ := range repo.Packages {
 				packages = append(packages, &repo.Packages[i])
@@ -652,7 +654,7 @@ func Test(t *testing.T) {
 			err = xml.NewDecoder(f).Decode(repo)
 			g.Expect(err).ToNot(HaveOccurred())
 
-			resolver := NewResolver(tt.nobest)
+			resolver := NewResolver(tt.nobest, ObjectiveNone, false, &policy.Policy{})
 			packages := []*api.Package{}
 			for i, _ := range repo.Packages {
 				packages = append(packages, &repo.Packages[i])
@@ -668,6 +670,52 @@ func Test(t *testing.T) {
 	}
 }
 
+func TestDeterministicResolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		requires []string
+		repofile string
+		nobest   bool
+	}{
+		{name: "should always resolve bash the same way", requires: []string{"bash", "fedora-release-server"}, repofile: "testdata/bash-fc32.xml"},
+		{name: "should always resolve perl-PathTools the same way", requires: []string{"perl-PathTools", "fedora-release-container"}, repofile: "testdata/perl-pathtools-fc32.xml", nobest: true},
+		{name: "should always resolve libvirt-devel the same way", requires: []string{"libvirt-devel", "fedora-release-server"}, repofile: "testdata/libvirt-devel-fc32.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			f, err := os.Open(tt.repofile)
+			g.Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+			repo := &api.Repository{}
+			g.Expect(xml.NewDecoder(f).Decode(repo)).To(Succeed())
+
+			var firstInstall, firstExclude []string
+			for run := 0; run < 10; run++ {
+				// the order in which packages get passed in must not matter either
+				packages := []*api.Package{}
+				for i := range repo.Packages {
+					packages = append(packages, &repo.Packages[i])
+				}
+				rand.New(rand.NewSource(int64(run))).Shuffle(len(packages), func(i, j int) {
+					packages[i], packages[j] = packages[j], packages[i]
+				})
+				resolver := NewResolver(tt.nobest, ObjectiveNone, false, &policy.Policy{})
+				g.Expect(resolver.LoadInvolvedPackages(packages)).To(Succeed())
+				g.Expect(resolver.ConstructRequirements(tt.requires)).To(Succeed())
+				install, exclude, err := resolver.Resolve()
+				g.Expect(err).ToNot(HaveOccurred())
+				if run == 0 {
+					firstInstall, firstExclude = pkgToString(install), pkgToString(exclude)
+					continue
+				}
+				g.Expect(pkgToString(install)).To(Equal(firstInstall))
+				g.Expect(pkgToString(exclude)).To(Equal(firstExclude))
+			}
+		})
+	}
+}
+
 func TestNewResolver(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -731,6 +779,97 @@ func TestNewResolver(t *testing.T) {
 			exclude:  []string{},
 			solvable: true,
 		},
+		{name: "with a rich or dependency where only one alternative exists", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b or c)"}, []string{}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testc-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+		{name: "with a rich and dependency", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b and (c or d))"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{"testd"}),
+			newPkg("testd", "1", []string{"testd", "d"}, []string{}, []string{"testc"}),
+		}, requires: []string{
+			"testa",
+			"testd",
+		},
+			install:  []string{"testa-0:1", "testb-0:1", "testd-0:1"},
+			exclude:  []string{"testc-0:1"},
+			solvable: true,
+		},
+		{name: "with a rich if dependency where the condition is met", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b if c)", "c"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testb-0:1", "testc-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+		{name: "with a rich if dependency where the condition does not exist", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b if c)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1"},
+			exclude:  []string{"testb-0:1"},
+			solvable: true,
+		},
+		{name: "with a rich if else dependency where the condition is not met", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b if c else d)"}, []string{}),
+			newPkg("testd", "1", []string{"testd", "d"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testd-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+		{name: "with a rich unless dependency where the condition is not met", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b unless c)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testb-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+		{name: "with a rich with dependency which has to be satisfied by a single package", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b with c)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"testc", "b", "c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testc-0:1"},
+			exclude:  []string{"testb-0:1"},
+			solvable: true,
+		},
+		{name: "with a rich dependency which can't be satisfied", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b without c)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b", "c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			solvable: false,
+		},
+		{name: "with an invalid rich dependency", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b and c or d)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			solvable: false,
+		},
 		// TODO: Add test cases.
 	}
 	focus := false
@@ -745,7 +884,7 @@ func TestNewResolver(t *testing.T) {
 			continue
 		}
 		t.Run(tt.name, func(t *testing.T) {
-			resolver := NewResolver(false)
+			resolver := NewResolver(false, ObjectiveNone, false, &policy.Policy{})
 			err := resolver.LoadInvolvedPackages(tt.packages)
 			if err != nil {
 				t.Fail()
@@ -769,6 +908,352 @@ func TestNewResolver(t *testing.T) {
 	}
 }
 
+func TestObjectives(t *testing.T) {
+	withSize := func(pkg *api.Package, size string) *api.Package {
+		pkg.Size.Installed = size
+		return pkg
+	}
+	packages := func() []*api.Package {
+		return []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"x"}, []string{}),
+			withSize(newPkg("testb", "1", []string{"testb", "x"}, []string{"y"}, []string{}), "4096"),
+			withSize(newPkg("testc", "1", []string{"testc", "x"}, []string{}, []string{}), "1048576"),
+			withSize(newPkg("testd", "1", []string{"testd", "y"}, []string{}, []string{}), "2048"),
+		}
+	}
+	tests := []struct {
+		name      string
+		objective Objective
+		install   []string
+	}{
+		{name: "should pick the solution with the least amount of packages", objective: ObjectivePackages, install: []string{"testa-0:1", "testc-0:1"}},
+		{name: "should pick the solution with the smallest installed size", objective: ObjectiveSize, install: []string{"testa-0:1", "testb-0:1", "testd-0:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			resolver := NewResolver(false, tt.objective, false, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(packages())).To(Succeed())
+			g.Expect(resolver.ConstructRequirements([]string{"testa"})).To(Succeed())
+			install, _, err := resolver.Resolve()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(install)).To(ConsistOf(tt.install))
+		})
+	}
+}
+
+func TestParseObjective(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(ParseObjective("")).To(Equal(ObjectiveNone))
+	g.Expect(ParseObjective("packages")).To(Equal(ObjectivePackages))
+	g.Expect(ParseObjective("size")).To(Equal(ObjectiveSize))
+	_, err := ParseObjective("speed")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestWeakDependencies(t *testing.T) {
+	withWeakDeps := func(pkg *api.Package, recommends []string, supplements []string) *api.Package {
+		for _, dep := range recommends {
+			pkg.Format.Recommends.Entries = append(pkg.Format.Recommends.Entries, api.Entry{Name: dep})
+		}
+		for _, dep := range supplements {
+			pkg.Format.Supplements.Entries = append(pkg.Format.Supplements.Entries, api.Entry{Name: dep})
+		}
+		return pkg
+	}
+	tests := []struct {
+		name     string
+		packages []*api.Package
+		weakDeps bool
+		install  []string
+	}{
+		{name: "should install recommended packages", packages: []*api.Package{
+			withWeakDeps(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), []string{"b"}, nil),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, weakDeps: true, install: []string{"testa-0:1", "testb-0:1"}},
+		{name: "should ignore recommended packages if weak dependencies are disabled", packages: []*api.Package{
+			withWeakDeps(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), []string{"b"}, nil),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, weakDeps: false, install: []string{"testa-0:1"}},
+		{name: "should ignore recommendations which nothing provides", packages: []*api.Package{
+			withWeakDeps(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), []string{"b"}, nil),
+		}, weakDeps: true, install: []string{"testa-0:1"}},
+		{name: "should ignore recommended packages which conflict", packages: []*api.Package{
+			withWeakDeps(newPkg("testa", "1", []string{"testa"}, []string{}, []string{"b"}), []string{"b"}, nil),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{}),
+		}, weakDeps: true, install: []string{"testa-0:1"}},
+		{name: "should not install supplementing packages if the condition is not met", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+			withWeakDeps(newPkg("testa-langpack", "1", []string{"testa-langpack"}, []string{}, []string{}), nil, []string{"(testa and c)"}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{}),
+		}, weakDeps: true, install: []string{"testa-0:1"}},
+		{name: "should install supplementing packages if the condition is met", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"c"}, []string{}),
+			withWeakDeps(newPkg("testa-langpack", "1", []string{"testa-langpack"}, []string{}, []string{}), nil, []string{"(testa and c)"}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{}),
+		}, weakDeps: true, install: []string{"testa-0:1", "testa-langpack-0:1", "testc-0:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			resolver := NewResolver(false, ObjectivePackages, tt.weakDeps, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(tt.packages)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements([]string{"testa"})).To(Succeed())
+			install, _, err := resolver.Resolve()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(pkgToString(install)).To(ConsistOf(tt.install))
+		})
+	}
+}
+
+func TestObsoletes(t *testing.T) {
+	withObsoletes := func(pkg *api.Package, obsoletes ...api.Entry) *api.Package {
+		pkg.Format.Obsoletes.Entries = append(pkg.Format.Obsoletes.Entries, obsoletes...)
+		return pkg
+	}
+	tests := []struct {
+		name     string
+		packages []*api.Package
+		requires []string
+		install  []string
+		reasons  []string
+	}{
+		{name: "should prefer the obsoleting package when the obsoleted one is requested", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb"}, []string{}, []string{}), api.Entry{Name: "testa"}),
+		}, requires: []string{"testa"}, install: []string{"testb-0:1"}},
+		{name: "should follow chains of obsoleting packages", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb"}, []string{}, []string{}), api.Entry{Name: "testa"}),
+			withObsoletes(newPkg("testc", "1", []string{"testc"}, []string{}, []string{}), api.Entry{Name: "testb"}),
+		}, requires: []string{"testa"}, install: []string{"testc-0:1"}},
+		{name: "should only obsolete matching versions", packages: []*api.Package{
+			newPkg("testa", "2", []string{"testa"}, []string{}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb"}, []string{}, []string{}), api.Entry{Name: "testa", Flags: "LT", Ver: "2"}),
+		}, requires: []string{"testa"}, install: []string{"testa-0:2"}},
+		{name: "should only obsolete package names", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa", "a"}, []string{}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb"}, []string{}, []string{}), api.Entry{Name: "a"}),
+		}, requires: []string{"testa", "testb"}, install: []string{"testa-0:1", "testb-0:1"}},
+		{name: "should not install obsoleted packages together with their replacement", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{"testa"}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb", "c"}, []string{}, []string{}), api.Entry{Name: "testa"}),
+			newPkg("testd", "1", []string{"testd"}, []string{"c"}, []string{}),
+		}, requires: []string{"testd"}, install: []string{"testb-0:1", "testd-0:1"}},
+		{name: "should explain conflicts with obsoleting packages", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+			withObsoletes(newPkg("testb", "1", []string{"testb"}, []string{}, []string{}), api.Entry{Name: "testa"}),
+			newPkg("testc", "1", []string{"testc"}, []string{"testa"}, []string{}),
+		}, requires: []string{"testb", "testc"}, reasons: []string{
+			"testb-0:1 was requested",
+			"testb-0:1 obsoletes testa, which matches testa-0:1",
+			"testc-0:1 requires testa, which is provided by testa-0:1",
+			"testc-0:1 was requested",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			resolver := NewResolver(false, ObjectivePackages, false, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(tt.packages)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements(tt.requires)).To(Succeed())
+			install, _, err := resolver.Resolve()
+			if tt.reasons == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(pkgToString(install)).To(ConsistOf(tt.install))
+				return
+			}
+			g.Expect(err).To(HaveOccurred())
+			reasons, err := resolver.Explain()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(reasons).To(ConsistOf(tt.reasons))
+		})
+	}
+}
+
+func TestMultilib(t *testing.T) {
+	withArch := func(pkg *api.Package, arch string) *api.Package {
+		pkg.Arch = arch
+		return pkg
+	}
+	tests := []struct {
+		name     string
+		packages []*api.Package
+		requires []string
+		install  []string
+	}{
+		{name: "should prefer the primary architecture", packages: []*api.Package{
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), "i686"),
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), "x86_64"),
+		}, requires: []string{"testa"}, install: []string{"testa-0:1.x86_64"}},
+		{name: "should pick explicitly requested architectures", packages: []*api.Package{
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), "i686"),
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), "x86_64"),
+		}, requires: []string{"testa", "testa.i686"}, install: []string{"testa-0:1.i686", "testa-0:1.x86_64"}},
+		{name: "should keep the best package of every architecture", packages: []*api.Package{
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{"b(x86-32)"}, []string{}), "i686"),
+			withArch(newPkg("testb", "1", []string{"testb", "b(x86-32)"}, []string{}, []string{}), "i686"),
+			withArch(newPkg("testb", "2", []string{"testb"}, []string{}, []string{}), "x86_64"),
+		}, requires: []string{"testa.i686", "testb"}, install: []string{"testa-0:1.i686", "testb-0:1.i686", "testb-0:2.x86_64"}},
+		{name: "should replace packages of any architecture with newer noarch packages", packages: []*api.Package{
+			withArch(newPkg("testa", "1", []string{"testa"}, []string{}, []string{}), "x86_64"),
+			withArch(newPkg("testa", "2", []string{"testa"}, []string{}, []string{}), "noarch"),
+		}, requires: []string{"testa"}, install: []string{"testa-0:2.noarch"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			resolver := NewResolver(false, ObjectivePackages, false, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(tt.packages)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements(tt.requires)).To(Succeed())
+			install, _, err := resolver.Resolve()
+			g.Expect(err).ToNot(HaveOccurred())
+			installed := []string{}
+			for _, pkg := range install {
+				installed = append(installed, pkg.String()+"."+pkg.Arch)
+			}
+			g.Expect(installed).To(ConsistOf(tt.install))
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	versioned := newPkg("testa", "1", []string{"testa"}, []string{}, []string{})
+	versioned.Format.Requires.Entries = append(versioned.Format.Requires.Entries, api.Entry{Name: "testb", Flags: "GE", Ver: "2"})
+
+	tests := []struct {
+		name     string
+		packages []*api.Package
+		excludes []string
+		locks    []string
+		install  []string
+		reasons  []string
+	}{
+		{name: "should not pick excluded alternatives", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"x"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "x"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"testc", "x"}, []string{}, []string{}),
+		}, excludes: []string{"testb"}, install: []string{"testa-0:1", "testc-0:1"}},
+		{name: "should pick locked versions", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"testb"}, []string{}),
+			newPkg("testb", "1", []string{"testb"}, []string{}, []string{}),
+			newPkg("testb", "2", []string{"testb"}, []string{}, []string{}),
+		}, locks: []string{"testb=1"}, install: []string{"testa-0:1", "testb-0:1"}},
+		{name: "should explain conflicts with locks", packages: []*api.Package{
+			versioned,
+			newPkg("testb", "1", []string{"testb"}, []string{}, []string{}),
+			newPkg("testb", "2", []string{"testb"}, []string{}, []string{}),
+		}, locks: []string{"testb=1"}, reasons: []string{
+			"testa-0:1 was requested",
+			"testa-0:1 requires testb >= 0:2, which is provided by testb-0:2",
+			"testb-0:2 is not allowed since testb is locked to 0:1",
+		}},
+		{name: "should explain requested packages which are excluded", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+		}, excludes: []string{"test*"}, reasons: []string{
+			"testa-0:1 was requested",
+			"testa-0:1 is excluded by \"test*\"",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			packagePolicy, err := policy.New(tt.excludes, tt.locks)
+			g.Expect(err).ToNot(HaveOccurred())
+			resolver := NewResolver(false, ObjectiveNone, false, packagePolicy)
+			g.Expect(resolver.LoadInvolvedPackages(tt.packages)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements([]string{"testa"})).To(Succeed())
+			install, _, err := resolver.Resolve()
+			if tt.reasons == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(pkgToString(install)).To(ConsistOf(tt.install))
+				return
+			}
+			g.Expect(err).To(HaveOccurred())
+			reasons, err := resolver.Explain()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(reasons).To(ConsistOf(tt.reasons))
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	versioned := newPkg("testa", "1", []string{"testa"}, []string{}, []string{})
+	versioned.Format.Requires.Entries = append(versioned.Format.Requires.Entries, api.Entry{Name: "testb", Flags: "GE", Ver: "2"})
+
+	tests := []struct {
+		name     string
+		packages []*api.Package
+		requires []string
+		reasons  []string
+	}{
+		{name: "with a missing dependency", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"d"}, []string{}),
+		}, requires: []string{
+			"testa",
+		}, reasons: []string{
+			"testa-0:1 requires d, but nothing provides d",
+		}},
+		{name: "with a dependency which is only available in a wrong version", packages: []*api.Package{
+			versioned,
+			newPkg("testb", "1", []string{"testb"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		}, reasons: []string{
+			"testa-0:1 requires testb >= 0:2, but only testb-0:1 is available",
+		}},
+		{name: "with a conflict between requested packages", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"b"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b"}, []string{}, []string{"c"}),
+			newPkg("testc", "1", []string{"testc", "c"}, []string{}, []string{}),
+			newPkg("testd", "1", []string{"testd"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+			"testc",
+			"testd",
+		}, reasons: []string{
+			"testa-0:1 requires b, which is provided by testb-0:1",
+			"testb-0:1 conflicts with c, which is provided by testc-0:1",
+			"testa-0:1 was requested",
+			"testc-0:1 was requested",
+		}},
+		{name: "with a rich dependency which can't be satisfied", packages: []*api.Package{
+			newPkg("testa", "1", []string{"testa"}, []string{"(b without c)"}, []string{}),
+			newPkg("testb", "1", []string{"testb", "b", "c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		}, reasons: []string{
+			"testa-0:1 requires (b without c)",
+			"testa-0:1 was requested",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			resolver := NewResolver(false, ObjectiveNone, false, &policy.Policy{})
+			g.Expect(resolver.LoadInvolvedPackages(tt.packages)).To(Succeed())
+			g.Expect(resolver.ConstructRequirements(tt.requires)).To(Succeed())
+			_, _, err := resolver.Resolve()
+			g.Expect(err).To(HaveOccurred())
+			reasons, err := resolver.Explain()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(reasons).To(ConsistOf(tt.reasons))
+		})
+	}
+}
+
+func TestExplainSolvable(t *testing.T) {
+	g := NewGomegaWithT(t)
+	resolver := NewResolver(false, ObjectiveNone, false, &policy.Policy{})
+	g.Expect(resolver.LoadInvolvedPackages([]*api.Package{
+		newPkg("testa", "1", []string{"testa"}, []string{}, []string{}),
+	})).To(Succeed())
+	g.Expect(resolver.ConstructRequirements([]string{"testa"})).To(Succeed())
+	_, err := resolver.Explain()
+	g.Expect(err).To(HaveOccurred())
+}
+
 func newPkg(name string, version string, provides []string, requires []string, conflicts []string) *api.Package {
 	pkg := &api.Package{}
 	pkg.Name = name